@@ -54,7 +54,6 @@ resource "edstem_challenge" "slide3_code" {
   slide_id    = edstem_slide.slide3.id
   lesson_id   = edstem_slide.slide3.lesson_id
   folder_path = "assets/code_challenge"
-  folder_sha  = sha1(join("", [for f in fileset(path.cwd, "assets/code_challenge/**") : filesha1("${path.cwd}/${f}")]))
 
   type                      = "custom"
   custom_mark_time_limit_ms = 2500
@@ -81,7 +80,6 @@ resource "edstem_challenge" "slide4_code" {
   lesson_id = edstem_slide.slide4.lesson_id
   // Just using the same content for code challenge.
   folder_path = "assets/code_challenge"
-  folder_sha  = sha1(join("", [for f in fileset(path.cwd, "assets/code_challenge/**") : filesha1("${path.cwd}/${f}")]))
 
   type                        = "code"
   testcase_json               = file("assets/testcases.json")
@@ -5,13 +5,21 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"time"
 )
 
+const apiBaseURL = "https://edstem.org/api"
+
 type Client struct {
 	CourseID   string
 	Token      string
 	HTTPClient *http.Client
+	// WorkspaceBackend selects how challenge workspaces are transferred, see wshelpers.NewWorkspace.
+	WorkspaceBackend string
+	// ExperimentalWorkspaceFSOps enables the new_symlink and chmod websocket operations, which are
+	// not confirmed to exist in Ed.
+	ExperimentalWorkspaceFSOps bool
 }
 
 func NewClient(course_id, token *string) (*Client, error) {
@@ -50,10 +58,10 @@ func (c *Client) HTTPRequest(path, method string, body bytes.Buffer, boundary *s
 	}
 
 	resp, err := c.HTTPClient.Do(req)
-	fmt.Println(resp.StatusCode, resp.Body)
 	if err != nil {
 		return nil, err
 	}
+	fmt.Println(resp.StatusCode, resp.Body)
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
 		respBody := new(bytes.Buffer)
@@ -67,5 +75,18 @@ func (c *Client) HTTPRequest(path, method string, body bytes.Buffer, boundary *s
 }
 
 func (c *Client) requestPath(path string) string {
-	return fmt.Sprintf("%s/%s", "https://edstem.org/api", path)
+	return fmt.Sprintf("%s/%s", apiBaseURL, path)
+}
+
+// IsAPIHost reports whether raw_url is on the same host as the Ed API, so it may be sent the token.
+func (c *Client) IsAPIHost(raw_url string) bool {
+	base, err := url.Parse(apiBaseURL)
+	if err != nil {
+		return false
+	}
+	target, err := url.Parse(raw_url)
+	if err != nil {
+		return false
+	}
+	return target.Scheme == base.Scheme && target.Host == base.Host
 }
@@ -4,28 +4,46 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"terraform-provider-edstem/internal/client"
 	"terraform-provider-edstem/internal/md2ed"
 	"terraform-provider-edstem/internal/resourceclients"
+	"terraform-provider-edstem/internal/wshelpers"
 
+	"github.com/hashicorp/terraform-plugin-framework-validators/stringvalidator"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/booldefault"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/float64default"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64default"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringdefault"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
 	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/markphelps/optional"
 )
 
 // Ensure the implementation satisfies the expected interfaces.
 var (
-	_ resource.Resource              = &challengeResource{}
-	_ resource.ResourceWithConfigure = &challengeResource{}
+	_ resource.Resource                     = &challengeResource{}
+	_ resource.ResourceWithConfigure        = &challengeResource{}
+	_ resource.ResourceWithValidateConfig   = &challengeResource{}
+	_ resource.ResourceWithConfigValidators = &challengeResource{}
 )
 
+// challengeTypes are the marking modes supported by the provider.
+var challengeTypes = []string{"none", "code", "custom"}
+
+// passbackScoringModes are the known ways of choosing which submission's score is passed back to the LMS.
+// Ed may accept others, so other values only get a warning.
+var passbackScoringModes = []string{"best", "latest"}
+
 // NewChallengeResource is a helper function to simplify the provider implementation.
 func NewChallengeResource() resource.Resource {
 	return &challengeResource{}
@@ -61,6 +79,15 @@ func (r *challengeResource) Metadata(_ context.Context, req resource.MetadataReq
 	resp.TypeName = req.ProviderTypeName + "_challenge"
 }
 
+// challengePrivateState records the hash of every file uploaded to the workspaces, so that
+// the next apply only sends the files which changed locally.
+type challengePrivateState struct {
+	WorkspaceHash string                       `json:"workspace_hash"`
+	Files         map[string]map[string]string `json:"files"`
+}
+
+const challengePrivateStateKey = "workspace_files"
+
 type challengeResourceModel struct {
 	SlideId  types.Int64 `tfsdk:"slide_id"`
 	LessonId types.Int64 `tfsdk:"lesson_id"`
@@ -69,6 +96,13 @@ type challengeResourceModel struct {
 	FolderPath  types.String `tfsdk:"folder_path"`
 	FolderSha   types.String `tfsdk:"folder_sha"`
 
+	FolderHash    types.String `tfsdk:"folder_hash"`
+	WorkspaceHash types.String `tfsdk:"workspace_hash"`
+
+	IgnorePatterns types.List `tfsdk:"ignore_patterns"`
+	OverlayPaths   types.List `tfsdk:"overlay_paths"`
+	TemplateVars   types.Map  `tfsdk:"template_vars"`
+
 	Type types.String `tfsdk:"type"`
 	// Points types.Int64  `tfsdk:"points"`
 
@@ -78,9 +112,9 @@ type challengeResourceModel struct {
 	TerminalCommand  types.String `tfsdk:"terminal_command"`
 	CustomRunCommand types.String `tfsdk:"custom_run_command"`
 
-	// PointLossThreshold types.Int64 `tfsdk:"point_loss_threshold"`
-	// PointLossEvery     types.Int64 `tfsdk:"point_loss_every"`
-	// PointLossAmount    types.Int64 `tfsdk:"point_loss_amount"`
+	PointLossThreshold types.Int64 `tfsdk:"point_loss_threshold"`
+	PointLossEvery     types.Int64 `tfsdk:"point_loss_every"`
+	PointLossAmount    types.Int64 `tfsdk:"point_loss_amount"`
 
 	PerTestcaseScores types.Bool `tfsdk:"per_testcase_scores"`
 
@@ -111,15 +145,22 @@ type challengeResourceModel struct {
 	IntermediateFiles    types.Bool `tfsdk:"feature_intermediate_files"`
 
 	CustomMarkTimeLimitMS    types.Int64  `tfsdk:"custom_mark_time_limit_ms"`
+	RunLimitCpuTimeMS        types.Int64  `tfsdk:"run_limit_cpu_time_ms"`
+	RunLimitWallTimeMS       types.Int64  `tfsdk:"run_limit_wall_time_ms"`
+	RunLimitMemoryBytes      types.Int64  `tfsdk:"run_limit_memory_bytes"`
+	RunLimitOutputSizeBytes  types.Int64  `tfsdk:"run_limit_output_size_bytes"`
+	RunLimitProcesses        types.Int64  `tfsdk:"run_limit_processes"`
 	TestcaseJSON             types.String `tfsdk:"testcase_json"`
+	TestcaseGenerate         types.Bool   `tfsdk:"testcase_generate"`
 	TestcasePty              types.Bool   `tfsdk:"testcase_pty"`
 	TestcaseEasy             types.Bool   `tfsdk:"testcase_easy"`
 	TestcaseMarkAll          types.Bool   `tfsdk:"testcase_mark_all"`
 	TestcaseOverlayTestFiles types.Bool   `tfsdk:"testcase_overlay_test_files"`
 
-	Criteria     types.String `tfsdk:"criteria"`
-	Rubric       types.String `tfsdk:"rubric"`
-	RubricPoints types.Int64  `tfsdk:"rubric_points"`
+	Criteria         types.String `tfsdk:"criteria"`
+	CriteriaToRubric types.Bool   `tfsdk:"criteria_to_rubric"`
+	Rubric           types.String `tfsdk:"rubric"`
+	RubricPoints     types.Int64  `tfsdk:"rubric_points"`
 }
 
 // Schema defines the schema for the resource.
@@ -145,14 +186,44 @@ func (r *challengeResource) Schema(_ context.Context, _ resource.SchemaRequest,
 				MarkdownDescription: "Folder path to the folder containing the workspace sources for this code slide.",
 			},
 			"folder_sha": schema.StringAttribute{
-				Required:            true,
-				MarkdownDescription: "SHA checksum of the folder content to ensure terraform recognises when changes to the content have been made. Recommended: `sha1(join(\"\", [for f in fileset(path.cwd, \"<folder_path>/**\") : filesha1(\"${path.cwd}/${f}\")]))`",
+				Optional:            true,
+				DeprecationMessage:  "folder_sha is no longer needed, changes to the workspace folders are detected through folder_hash.",
+				MarkdownDescription: "Deprecated: changes to the workspace folders are now detected through `folder_hash`.",
 			},
-			"type": schema.StringAttribute{
-				Default:             stringdefault.StaticString("none"),
+			"folder_hash": schema.StringAttribute{
+				Computed: true,
+				PlanModifiers: []planmodifier.String{
+					challengeFolderHashModifier{},
+				},
+				MarkdownDescription: "Hash of the paths, contents and modes of the `scaffold`, `solution` and `testbase` folders in `folder_path`, merged with any `overlay_paths` and with templates rendered, computed by the provider when planning.",
+			},
+			"ignore_patterns": schema.ListAttribute{
+				ElementType:         types.StringType,
 				Optional:            true,
+				MarkdownDescription: "Paths which are not uploaded, in gitignore syntax, matched inside each of the `scaffold`, `solution` and `testbase` folders. `.edignore` files in `folder_path` are also read, and follow gitignore exactly, so one at the root of `folder_path` names paths like `/scaffold/build`. As with `.git/info/exclude`, an `.edignore` file overrides these patterns, and can include a path again with `!`. Ignored paths are also left out of `folder_hash` and `workspace_hash`.",
+			},
+			"overlay_paths": schema.ListAttribute{
+				ElementType:         types.StringType,
+				Optional:            true,
+				MarkdownDescription: "Shared folders layered under `folder_path`, each with its own `scaffold`, `solution` and `testbase` folders. The layers are merged in order before syncing, with later layers winning and `folder_path` on top, so a challenge only needs the files it adds or replaces. Each folder's `.edignore` files apply to its own files, while `ignore_patterns` and the `.edignore` files of `folder_path` apply to the merged workspaces.",
+			},
+			"template_vars": schema.MapAttribute{
+				ElementType:         types.StringType,
+				Optional:            true,
+				MarkdownDescription: "Variables for the workspace files ending in `.tmpl`, which are rendered with Go's `text/template` and uploaded without the suffix, so `main.py.tmpl` becomes `main.py`. Variables are used as `{{ .year }}`, and using one which isn't set is an error. The rendered files are covered by `folder_hash`. When not set, `.tmpl` files are uploaded as they are.",
+			},
+			"workspace_hash": schema.StringAttribute{
 				Computed:            true,
-				MarkdownDescription: "The way the code challenge will be executed / marked. `none`, `code`, `custom` are all supported formats.",
+				MarkdownDescription: "Hash of the `scaffold`, `solution` and `testbase` workspaces as last seen in Ed. If the workspaces are edited in Ed, `folder_hash` is refreshed to this value so the next apply restores them.",
+			},
+			"type": schema.StringAttribute{
+				Default:  stringdefault.StaticString("none"),
+				Optional: true,
+				Computed: true,
+				Validators: []validator.String{
+					stringvalidator.OneOf(challengeTypes...),
+				},
+				MarkdownDescription: "The way the code challenge will be executed / marked. `none`, `code`, `custom` are all supported formats. `code` is marked by the testcases in `testcase_json` and uses the `testcase_*` attributes. `custom` is marked by `custom_run_command`. The `run_limit_*` attributes apply to both.",
 			},
 			"build_command": schema.StringAttribute{
 				Default:             stringdefault.StaticString(""),
@@ -183,6 +254,24 @@ func (r *challengeResource) Schema(_ context.Context, _ resource.SchemaRequest,
 				Computed:            true,
 				MarkdownDescription: "When using the `custom` type, the run command used to generate the test json.",
 			},
+			"point_loss_threshold": schema.Int64Attribute{
+				Default:             int64default.StaticInt64(0),
+				Optional:            true,
+				Computed:            true,
+				MarkdownDescription: "Number of submissions allowed before points start being lost.",
+			},
+			"point_loss_every": schema.Int64Attribute{
+				Default:             int64default.StaticInt64(0),
+				Optional:            true,
+				Computed:            true,
+				MarkdownDescription: "Points are lost again every this many submissions past `point_loss_threshold`. Must be at least 1 when `point_loss_amount` is set.",
+			},
+			"point_loss_amount": schema.Int64Attribute{
+				Default:             int64default.StaticInt64(0),
+				Optional:            true,
+				Computed:            true,
+				MarkdownDescription: "Percentage of the points lost each time, from 0 to 100. 0 disables the penalty.",
+			},
 			"per_testcase_scores": schema.BoolAttribute{
 				Default:             booldefault.StaticBool(false),
 				Optional:            true,
@@ -215,6 +304,10 @@ func (r *challengeResource) Schema(_ context.Context, _ resource.SchemaRequest,
 			},
 			"passback_scoring_mode": schema.StringAttribute{
 				Optional: true,
+				Validators: []validator.String{
+					passbackScoringModeValidator{},
+				},
+				MarkdownDescription: "Which submission's score is passed back, such as `best` or `latest`.",
 			},
 			"passback_max_automatic_score": schema.Float64Attribute{
 				Default:  float64default.StaticFloat64(0),
@@ -312,13 +405,43 @@ func (r *challengeResource) Schema(_ context.Context, _ resource.SchemaRequest,
 			},
 			"custom_mark_time_limit_ms": schema.Int64Attribute{
 				Optional:            true,
-				MarkdownDescription: "Time limit on custom marking script to complete in milliseconds.",
+				MarkdownDescription: "Time limit on custom marking script to complete in milliseconds. Sets both the CPU and wall time limits.",
+				DeprecationMessage:  "Use run_limit_cpu_time_ms and run_limit_wall_time_ms, which also apply to `code` challenges.",
+			},
+			"run_limit_cpu_time_ms": schema.Int64Attribute{
+				Optional:            true,
+				MarkdownDescription: "CPU time limit when marking `code` and `custom` challenges, in milliseconds. Testcases can override it with `run_limit.cpu_time` in `testcase_json`.",
+			},
+			"run_limit_wall_time_ms": schema.Int64Attribute{
+				Optional:            true,
+				MarkdownDescription: "Wall-clock time limit when marking `code` and `custom` challenges, in milliseconds. Testcases can override it with `run_limit.wall_time`.",
+			},
+			"run_limit_memory_bytes": schema.Int64Attribute{
+				Optional:            true,
+				MarkdownDescription: "Memory limit when marking `code` and `custom` challenges, in bytes. Testcases can override it with `run_limit.memory`.",
+			},
+			"run_limit_output_size_bytes": schema.Int64Attribute{
+				Optional:            true,
+				MarkdownDescription: "Limit on the output of a marking run, in bytes. Testcases can override it with `run_limit.output_size`.",
+			},
+			"run_limit_processes": schema.Int64Attribute{
+				Optional:            true,
+				MarkdownDescription: "Maximum number of processes a marking run can start. Testcases can override it with `run_limit.processes`.",
 			},
 			"testcase_json": schema.StringAttribute{
-				Default:             stringdefault.StaticString("[]"),
+				Default:  stringdefault.StaticString("[]"),
+				Optional: true,
+				Computed: true,
+				PlanModifiers: []planmodifier.String{
+					challengeTestcaseGenerateModifier{},
+				},
+				MarkdownDescription: "JSON string containing all test cases for `code` style challenges. See examples for the format. Names must be unique, `score` can't exceed a non-zero `max_score`, and every `stdin_path` and `expect_path` must exist in the testbase of `folder_path` or `overlay_paths`.",
+			},
+			"testcase_generate": schema.BoolAttribute{
+				Default:             booldefault.StaticBool(false),
 				Optional:            true,
 				Computed:            true,
-				MarkdownDescription: "JSON string containing all test cases for `code` style challenges. See examples for the format.",
+				MarkdownDescription: "Generate `testcase_json` from numbered `N.in`, `N.out` and `N.args` files in the testbase of `folder_path` and any `overlay_paths`. `N.in` is used as stdin, the output is diffed against `N.out`, and `N.args` is appended to `run_command`. Each testcase scores 1. Overrides for `name`, `description`, `score`, `max_score`, `hidden`, `private`, `skip` and `check_type` can be given under `defaults`, or under `testcases` keyed by `N` (or `folder/N`), in `folder_path/testcase_overrides.yaml` or `.json`. Can't be used with `testcase_json`.",
 			},
 			"testcase_pty": schema.BoolAttribute{
 				Default:             booldefault.StaticBool(false),
@@ -349,10 +472,19 @@ func (r *challengeResource) Schema(_ context.Context, _ resource.SchemaRequest,
 				Computed:            true,
 				MarkdownDescription: "Old criteria format for marking. New lessons won't have this.",
 			},
-			"rubric": schema.StringAttribute{
-				Default:             stringdefault.StaticString("{}"),
+			"criteria_to_rubric": schema.BoolAttribute{
+				Default:             booldefault.StaticBool(false),
 				Optional:            true,
 				Computed:            true,
+				MarkdownDescription: "Convert `criteria` to a rubric on apply, and clear the criteria in Ed. Each criterion becomes a section where one item is selected, and each level an item titled with its description and worth its mark. Needs `criteria` with at least one criterion, and can't be used with `rubric`. Run `go run main.go convert_criteria` with `--dry_run` to preview the rubric.",
+			},
+			"rubric": schema.StringAttribute{
+				Default:  stringdefault.StaticString("{}"),
+				Optional: true,
+				Computed: true,
+				PlanModifiers: []planmodifier.String{
+					challengeCriteriaRubricModifier{},
+				},
 				MarkdownDescription: "New rubric format for marking. Rubric text fields support markdown. [PLEASE AVOID USING (or remove after initial apply) - THIS MAY REMOVE STUDENT FEEDBACK ON REAPPLY]",
 			},
 			"rubric_points": schema.Int64Attribute{
@@ -363,6 +495,599 @@ func (r *challengeResource) Schema(_ context.Context, _ resource.SchemaRequest,
 	}
 }
 
+// ConfigValidators rejects attributes which the chosen type would ignore.
+func (r *challengeResource) ConfigValidators(_ context.Context) []resource.ConfigValidator {
+	return []resource.ConfigValidator{
+		challengeTypeAttributesValidator{},
+	}
+}
+
+// challengeTypeAttribute lists the types an attribute applies to. Setting it for any other type
+// is an error, or a warning for flags that are harmless when ignored.
+type challengeTypeAttribute struct {
+	name  string
+	types []string
+	warn  bool
+}
+
+var challengeTypeAttributes = []challengeTypeAttribute{
+	{name: "testcase_json", types: []string{"code"}},
+	{name: "testcase_generate", types: []string{"code"}},
+	{name: "testcase_pty", types: []string{"code"}, warn: true},
+	{name: "testcase_easy", types: []string{"code"}, warn: true},
+	{name: "testcase_mark_all", types: []string{"code"}, warn: true},
+	{name: "testcase_overlay_test_files", types: []string{"code"}, warn: true},
+	{name: "custom_run_command", types: []string{"custom"}},
+	{name: "custom_mark_time_limit_ms", types: []string{"custom"}},
+	{name: "run_limit_cpu_time_ms", types: []string{"code", "custom"}},
+	{name: "run_limit_wall_time_ms", types: []string{"code", "custom"}},
+	{name: "run_limit_memory_bytes", types: []string{"code", "custom"}},
+	{name: "run_limit_output_size_bytes", types: []string{"code", "custom"}},
+	{name: "run_limit_processes", types: []string{"code", "custom"}},
+}
+
+// challengeTypeAttributesValidator checks every attribute in challengeTypeAttributes applies to the configured type.
+type challengeTypeAttributesValidator struct{}
+
+func (v challengeTypeAttributesValidator) Description(_ context.Context) string {
+	return "Checks that the configured attributes apply to the challenge type."
+}
+
+func (v challengeTypeAttributesValidator) MarkdownDescription(ctx context.Context) string {
+	return v.Description(ctx)
+}
+
+func (v challengeTypeAttributesValidator) ValidateResource(ctx context.Context, req resource.ValidateConfigRequest, resp *resource.ValidateConfigResponse) {
+	var challenge_type types.String
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("type"), &challenge_type)...)
+	if resp.Diagnostics.HasError() || challenge_type.IsUnknown() {
+		return
+	}
+	type_name := challenge_type.ValueString()
+	if challenge_type.IsNull() {
+		type_name = "none"
+	}
+
+	for _, attribute := range challengeTypeAttributes {
+		applies := false
+		for _, t := range attribute.types {
+			applies = applies || t == type_name
+		}
+		if applies {
+			continue
+		}
+		var value attr.Value
+		diags := req.Config.GetAttribute(ctx, path.Root(attribute.name), &value)
+		resp.Diagnostics.Append(diags...)
+		if diags.HasError() {
+			return
+		}
+		if value.IsNull() {
+			continue
+		}
+		summary := "Attribute Not Used By Challenge Type"
+		detail := fmt.Sprintf("%s only applies to challenges of type %s, so it would be ignored for type %q. Remove it or change the type.",
+			attribute.name, strings.Join(attribute.types, " or "), type_name)
+		if attribute.warn {
+			resp.Diagnostics.AddAttributeWarning(path.Root(attribute.name), summary, detail)
+		} else {
+			resp.Diagnostics.AddAttributeError(path.Root(attribute.name), summary, detail)
+		}
+	}
+}
+
+// passbackScoringModeValidator warns about scoring modes other than passbackScoringModes.
+type passbackScoringModeValidator struct{}
+
+func (v passbackScoringModeValidator) Description(_ context.Context) string {
+	return fmt.Sprintf("Warns if the value is not one of %s.", strings.Join(passbackScoringModes, ", "))
+}
+
+func (v passbackScoringModeValidator) MarkdownDescription(ctx context.Context) string {
+	return v.Description(ctx)
+}
+
+func (v passbackScoringModeValidator) ValidateString(ctx context.Context, req validator.StringRequest, resp *validator.StringResponse) {
+	if req.ConfigValue.IsNull() || req.ConfigValue.IsUnknown() || req.ConfigValue.ValueString() == "" {
+		return
+	}
+	for _, mode := range passbackScoringModes {
+		if req.ConfigValue.ValueString() == mode {
+			return
+		}
+	}
+	resp.Diagnostics.AddAttributeWarning(
+		req.Path,
+		"Unknown Passback Scoring Mode",
+		fmt.Sprintf("%q is not one of the known scoring modes %s, and may be rejected by Ed.", req.ConfigValue.ValueString(), strings.Join(passbackScoringModes, ", ")),
+	)
+}
+
+// challengeFolderHashModifier plans the hash of the local workspace folders, so any change to them triggers an update.
+type challengeFolderHashModifier struct{}
+
+func (m challengeFolderHashModifier) Description(_ context.Context) string {
+	return "Computes the hash of the scaffold, solution and testbase folders in folder_path, merged with overlay_paths and with templates rendered."
+}
+
+func (m challengeFolderHashModifier) MarkdownDescription(ctx context.Context) string {
+	return m.Description(ctx)
+}
+
+func (m challengeFolderHashModifier) PlanModifyString(ctx context.Context, req planmodifier.StringRequest, resp *planmodifier.StringResponse) {
+	if req.Plan.Raw.IsNull() {
+		return
+	}
+	var plan challengeResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if plan.FolderPath.IsUnknown() || plan.IgnorePatterns.IsUnknown() || plan.OverlayPaths.IsUnknown() || !knownMap(plan.TemplateVars) {
+		resp.PlanValue = types.StringUnknown()
+		return
+	}
+	local, diags := plan.localChallenge(ctx)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	folder_hash, err := local.WorkspaceHash()
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(
+			path.Root("folder_path"),
+			"Error Hashing Workspace Folder",
+			fmt.Sprintf("Could not hash %s: %s", plan.FolderPath.ValueString(), err.Error()),
+		)
+		return
+	}
+	resp.PlanValue = types.StringValue(folder_hash)
+}
+
+// challengeTestcaseGenerateModifier plans the generated testcases when testcase_generate is set, so they
+// are compared with the testcases in Ed like any written in testcase_json.
+type challengeTestcaseGenerateModifier struct{}
+
+func (m challengeTestcaseGenerateModifier) Description(_ context.Context) string {
+	return "Generates the testcases from the N.in, N.out and N.args files in the testbase when testcase_generate is set."
+}
+
+func (m challengeTestcaseGenerateModifier) MarkdownDescription(ctx context.Context) string {
+	return m.Description(ctx)
+}
+
+func (m challengeTestcaseGenerateModifier) PlanModifyString(ctx context.Context, req planmodifier.StringRequest, resp *planmodifier.StringResponse) {
+	if req.Plan.Raw.IsNull() {
+		return
+	}
+	var plan challengeResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if plan.TestcaseGenerate.IsUnknown() {
+		resp.PlanValue = types.StringUnknown()
+		return
+	}
+	if !plan.TestcaseGenerate.ValueBool() {
+		return
+	}
+	if plan.FolderPath.IsUnknown() || plan.RunCommand.IsUnknown() || plan.IgnorePatterns.IsUnknown() || plan.OverlayPaths.IsUnknown() || !knownMap(plan.TemplateVars) {
+		resp.PlanValue = types.StringUnknown()
+		return
+	}
+
+	local, diags := plan.localChallenge(ctx)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	testcases, err := resourceclients.GenerateTestCases(local, plan.RunCommand.ValueString())
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(
+			path.Root("testcase_generate"),
+			"Error Generating Testcases",
+			fmt.Sprintf("Could not generate testcases from %s: %s", plan.FolderPath.ValueString(), err.Error()),
+		)
+		return
+	}
+	testcase_json, err := json.MarshalIndent(testcases, "", "  ")
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(
+			path.Root("testcase_generate"),
+			"Error Generating Testcases",
+			fmt.Sprintf("Could not encode testcases: %s", err.Error()),
+		)
+		return
+	}
+	resp.PlanValue = types.StringValue(string(testcase_json))
+}
+
+// challengeCriteriaRubricModifier plans the rubric converted from criteria when criteria_to_rubric is set.
+type challengeCriteriaRubricModifier struct{}
+
+func (m challengeCriteriaRubricModifier) Description(_ context.Context) string {
+	return "Converts criteria to a rubric when criteria_to_rubric is set."
+}
+
+func (m challengeCriteriaRubricModifier) MarkdownDescription(ctx context.Context) string {
+	return m.Description(ctx)
+}
+
+func (m challengeCriteriaRubricModifier) PlanModifyString(ctx context.Context, req planmodifier.StringRequest, resp *planmodifier.StringResponse) {
+	if req.Plan.Raw.IsNull() {
+		return
+	}
+	var convert types.Bool
+	resp.Diagnostics.Append(req.Plan.GetAttribute(ctx, path.Root("criteria_to_rubric"), &convert)...)
+	var criteria types.String
+	resp.Diagnostics.Append(req.Plan.GetAttribute(ctx, path.Root("criteria"), &criteria)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if convert.IsUnknown() {
+		resp.PlanValue = types.StringUnknown()
+		return
+	}
+	if !convert.ValueBool() {
+		return
+	}
+	if criteria.IsUnknown() {
+		resp.PlanValue = types.StringUnknown()
+		return
+	}
+
+	rubric, err := criteriaRubric(criteria.ValueString())
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(
+			path.Root("criteria"),
+			"Error Converting Criteria",
+			fmt.Sprintf("Could not convert criteria to a rubric: %s", err.Error()),
+		)
+		return
+	}
+	resp.PlanValue = types.StringValue(rubric)
+}
+
+// criteriaRubric converts criteria JSON to rubric JSON.
+func criteriaRubric(criteria_json string) (string, error) {
+	criteria := []resourceclients.Criteria{}
+	err := json.NewDecoder(strings.NewReader(criteria_json)).Decode(&criteria)
+	if err != nil {
+		return "", err
+	}
+	rubric, err := resourceclients.CriteriaToRubric(criteria)
+	if err != nil {
+		return "", err
+	}
+	rubric_json, err := json.MarshalIndent(rubric, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(rubric_json), nil
+}
+
+// knownMap reports whether a map and every value in it are known, as a map such as template_vars
+// can reference values only known after apply.
+func knownMap(m types.Map) bool {
+	if m.IsUnknown() {
+		return false
+	}
+	for _, value := range m.Elements() {
+		if value.IsUnknown() {
+			return false
+		}
+	}
+	return true
+}
+
+// stringList reads a list of strings attribute, such as ignore_patterns, which may be null.
+func stringList(ctx context.Context, list types.List) ([]string, diag.Diagnostics) {
+	patterns := make([]string, 0, len(list.Elements()))
+	if list.IsNull() || list.IsUnknown() {
+		return patterns, nil
+	}
+	diags := list.ElementsAs(ctx, &patterns, false)
+	return patterns, diags
+}
+
+// localChallenge loads the overlay_paths, .edignore files, ignore_patterns and template_vars of the challenge.
+func (model *challengeResourceModel) localChallenge(ctx context.Context) (*wshelpers.LocalChallenge, diag.Diagnostics) {
+	patterns, diags := stringList(ctx, model.IgnorePatterns)
+	overlay_paths, overlay_diags := stringList(ctx, model.OverlayPaths)
+	diags.Append(overlay_diags...)
+	if diags.HasError() {
+		return nil, diags
+	}
+	local, err := wshelpers.LoadLocalChallenge(model.FolderPath.ValueString(), overlay_paths, patterns)
+	if err != nil {
+		diags.AddAttributeError(
+			path.Root("folder_path"),
+			"Error Reading Ignore Files",
+			fmt.Sprintf("Could not read the %s files of %s: %s", wshelpers.IgnoreFileName, model.FolderPath.ValueString(), err.Error()),
+		)
+		return nil, diags
+	}
+	if !model.TemplateVars.IsNull() && !model.TemplateVars.IsUnknown() {
+		local.TemplateVars = make(map[string]string)
+		diags.Append(model.TemplateVars.ElementsAs(ctx, &local.TemplateVars, false)...)
+	}
+	return local, diags
+}
+
+// ValidateConfig checks the point loss settings and testcase_json before any API call. Testcase names
+// must be unique, scores must fit within any max_score, and the stdin and expected output files must
+// exist in the testbase merged from folder_path and overlay_paths.
+func (r *challengeResource) ValidateConfig(ctx context.Context, req resource.ValidateConfigRequest, resp *resource.ValidateConfigResponse) {
+	var config challengeResourceModel
+	diags := req.Config.Get(ctx, &config)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	config.validatePointLoss(&resp.Diagnostics)
+	config.validateRunLimit(&resp.Diagnostics)
+	config.validateOverlayPaths(&resp.Diagnostics)
+
+	if config.CriteriaToRubric.ValueBool() {
+		if !config.Rubric.IsNull() {
+			resp.Diagnostics.AddAttributeError(
+				path.Root("criteria_to_rubric"),
+				"Conflicting Rubric",
+				"criteria_to_rubric can't be used with rubric, as the converted criteria would replace it.",
+			)
+		} else if config.Criteria.IsNull() {
+			resp.Diagnostics.AddAttributeError(
+				path.Root("criteria_to_rubric"),
+				"Missing Criteria",
+				"criteria_to_rubric needs the criteria to convert, otherwise an empty rubric would replace the criteria in Ed.",
+			)
+		} else if !config.Criteria.IsUnknown() {
+			_, err := criteriaRubric(config.Criteria.ValueString())
+			if err != nil {
+				resp.Diagnostics.AddAttributeError(
+					path.Root("criteria"),
+					"Invalid Criteria",
+					fmt.Sprintf("Could not convert criteria to a rubric: %s", err.Error()),
+				)
+			}
+		}
+	}
+
+	if config.TestcaseGenerate.ValueBool() && !config.TestcaseJSON.IsNull() {
+		resp.Diagnostics.AddAttributeError(
+			path.Root("testcase_generate"),
+			"Conflicting Testcases",
+			"testcase_generate can't be used with testcase_json, as the generated testcases would replace it.",
+		)
+		return
+	}
+	if config.TestcaseJSON.IsNull() || config.TestcaseJSON.IsUnknown() {
+		return
+	}
+	testcases := []resourceclients.TestCase{}
+	err := json.NewDecoder(strings.NewReader(config.TestcaseJSON.ValueString())).Decode(&testcases)
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(
+			path.Root("testcase_json"),
+			"Invalid Testcases",
+			fmt.Sprintf("Could not parse testcase_json: %s", err.Error()),
+		)
+		return
+	}
+
+	addError := func(index int, testcase resourceclients.TestCase, message string) {
+		resp.Diagnostics.AddAttributeError(
+			path.Root("testcase_json"),
+			"Invalid Testcase",
+			fmt.Sprintf("Testcase %d (%q): %s", index, testcase.Name, message),
+		)
+	}
+
+	names := make(map[string]int)
+	for i, testcase := range testcases {
+		if first, ok := names[testcase.Name]; ok {
+			addError(i, testcase, fmt.Sprintf("Name is already used by testcase %d", first))
+		} else {
+			names[testcase.Name] = i
+		}
+		// score is awarded for passing, max_score caps partial marks and is unused when 0.
+		if testcase.Score < 0 || testcase.MaxScore < 0 {
+			addError(i, testcase, "score and max_score can't be negative")
+		} else if testcase.MaxScore > 0 && testcase.Score > testcase.MaxScore {
+			addError(i, testcase, fmt.Sprintf("score %d is more than max_score %d", testcase.Score, testcase.MaxScore))
+		}
+		for _, limit := range []struct {
+			name  string
+			value optional.Int64
+		}{
+			{"cpu_time", testcase.RunLimit.CpuTime},
+			{"wall_time", testcase.RunLimit.WallTime},
+			{"memory", testcase.RunLimit.Memory},
+			{"output_size", testcase.RunLimit.OutputSize},
+			{"processes", testcase.RunLimit.Processes},
+		} {
+			if limit.value.OrElse(0) < 0 {
+				addError(i, testcase, fmt.Sprintf("run_limit %s can't be negative", limit.name))
+			}
+		}
+		for _, output_file := range testcase.OutputFiles {
+			// Output files are written by the run, so only their location can be checked.
+			if _, ok := resourceclients.TestcaseWorkspacePath(output_file); !ok {
+				addError(i, testcase, fmt.Sprintf("output_files entry %s is outside the workspace", output_file))
+			}
+		}
+	}
+
+	if config.FolderPath.IsNull() || config.FolderPath.IsUnknown() || config.IgnorePatterns.IsUnknown() || config.OverlayPaths.IsUnknown() || !knownMap(config.TemplateVars) {
+		return
+	}
+	local, diags := config.localChallenge(ctx)
+	resp.Diagnostics.Append(diags...)
+	if diags.HasError() {
+		return
+	}
+	testbase, err := local.ReadRepo("testbase")
+	if err != nil {
+		// Missing overlays are reported by validateOverlayPaths, and template errors when folder_hash is planned.
+		return
+	}
+	checkTestbaseFile := func(index int, testcase resourceclients.TestCase, attribute string, testcase_path string) {
+		rel_path, ok := resourceclients.TestcaseWorkspacePath(testcase_path)
+		if !ok {
+			addError(index, testcase, fmt.Sprintf("%s %s is outside the workspace", attribute, testcase_path))
+			return
+		}
+		file, ok := testbase[rel_path]
+		if !ok {
+			if local.Rules.Ignored("testbase", rel_path, false) {
+				addError(index, testcase, fmt.Sprintf("%s %s is ignored, so it won't be uploaded to the testbase", attribute, testcase_path))
+			} else {
+				addError(index, testcase, fmt.Sprintf("%s %s does not exist in the testbase of folder_path or overlay_paths", attribute, testcase_path))
+			}
+			return
+		}
+		if file.IsDir {
+			addError(index, testcase, fmt.Sprintf("%s %s is a directory", attribute, testcase_path))
+		}
+	}
+	for i, testcase := range testcases {
+		if testcase.StdinPath != "" {
+			checkTestbaseFile(i, testcase, "stdin_path", testcase.StdinPath)
+		}
+		for _, check := range testcase.Checks {
+			if check.ExpectPath != "" {
+				checkTestbaseFile(i, testcase, fmt.Sprintf("Check %q expect_path", check.Name), check.ExpectPath)
+			}
+		}
+	}
+}
+
+// validateOverlayPaths checks every overlay is an existing folder, other than folder_path itself.
+func (model *challengeResourceModel) validateOverlayPaths(diags *diag.Diagnostics) {
+	if model.OverlayPaths.IsNull() || model.OverlayPaths.IsUnknown() {
+		return
+	}
+	for i, element := range model.OverlayPaths.Elements() {
+		overlay_path, ok := element.(types.String)
+		if !ok || overlay_path.IsUnknown() {
+			continue
+		}
+		attr_path := path.Root("overlay_paths").AtListIndex(i)
+		if overlay_path.IsNull() || overlay_path.ValueString() == "" {
+			diags.AddAttributeError(attr_path, "Invalid Overlay Path", "Overlay paths can't be empty.")
+			continue
+		}
+		if !model.FolderPath.IsUnknown() && filepath.Clean(overlay_path.ValueString()) == filepath.Clean(model.FolderPath.ValueString()) {
+			diags.AddAttributeError(attr_path, "Invalid Overlay Path", fmt.Sprintf("%s is folder_path, which is always the top layer.", overlay_path.ValueString()))
+			continue
+		}
+		info, err := os.Stat(overlay_path.ValueString())
+		if err != nil {
+			diags.AddAttributeError(attr_path, "Invalid Overlay Path", fmt.Sprintf("Could not read overlay folder %s: %s", overlay_path.ValueString(), err.Error()))
+			continue
+		}
+		if !info.IsDir() {
+			diags.AddAttributeError(attr_path, "Invalid Overlay Path", fmt.Sprintf("%s is not a folder.", overlay_path.ValueString()))
+		}
+	}
+}
+
+// validateRunLimit checks the run limits are not negative and don't conflict with custom_mark_time_limit_ms.
+func (model *challengeResourceModel) validateRunLimit(diags *diag.Diagnostics) {
+	for _, attr := range []struct {
+		name  string
+		value types.Int64
+	}{
+		{"run_limit_cpu_time_ms", model.RunLimitCpuTimeMS},
+		{"run_limit_wall_time_ms", model.RunLimitWallTimeMS},
+		{"run_limit_memory_bytes", model.RunLimitMemoryBytes},
+		{"run_limit_output_size_bytes", model.RunLimitOutputSizeBytes},
+		{"run_limit_processes", model.RunLimitProcesses},
+	} {
+		if attr.value.ValueInt64() < 0 {
+			diags.AddAttributeError(
+				path.Root(attr.name),
+				"Invalid Run Limit",
+				fmt.Sprintf("%s can't be negative, got %d.", attr.name, attr.value.ValueInt64()),
+			)
+		}
+	}
+	if !model.CustomMarkTimeLimitMS.IsNull() && (!model.RunLimitCpuTimeMS.IsNull() || !model.RunLimitWallTimeMS.IsNull()) {
+		diags.AddAttributeError(
+			path.Root("custom_mark_time_limit_ms"),
+			"Conflicting Run Limits",
+			"custom_mark_time_limit_ms sets both time limits, so it can't be used with run_limit_cpu_time_ms or run_limit_wall_time_ms.",
+		)
+	}
+}
+
+// validatePointLoss checks the point loss settings are in range. Unknown values are skipped.
+func (model *challengeResourceModel) validatePointLoss(diags *diag.Diagnostics) {
+	for _, attr := range []struct {
+		name  string
+		value types.Int64
+	}{
+		{"point_loss_threshold", model.PointLossThreshold},
+		{"point_loss_every", model.PointLossEvery},
+		{"point_loss_amount", model.PointLossAmount},
+	} {
+		if attr.value.ValueInt64() < 0 {
+			diags.AddAttributeError(
+				path.Root(attr.name),
+				"Invalid Point Loss",
+				fmt.Sprintf("%s can't be negative, got %d.", attr.name, attr.value.ValueInt64()),
+			)
+		}
+	}
+	if model.PointLossAmount.ValueInt64() > 100 {
+		diags.AddAttributeError(
+			path.Root("point_loss_amount"),
+			"Invalid Point Loss",
+			fmt.Sprintf("point_loss_amount is a percentage and can't be more than 100, got %d.", model.PointLossAmount.ValueInt64()),
+		)
+	}
+	if model.PointLossAmount.ValueInt64() > 0 && !model.PointLossEvery.IsUnknown() && model.PointLossEvery.ValueInt64() < 1 {
+		diags.AddAttributeError(
+			path.Root("point_loss_every"),
+			"Invalid Point Loss",
+			"point_loss_every must be at least 1 when point_loss_amount is set.",
+		)
+	}
+}
+
+func optionalInt64(value types.Int64) optional.Int64 {
+	if value.IsNull() || value.IsUnknown() {
+		return optional.Int64{}
+	}
+	return optional.NewInt64(value.ValueInt64())
+}
+
+func int64Value(value optional.Int64) types.Int64 {
+	if !value.Present() {
+		return types.Int64Null()
+	}
+	return types.Int64Value(value.MustGet())
+}
+
+// configuredInt64 refreshes current from value, unless current is not set.
+func configuredInt64(current types.Int64, value optional.Int64) types.Int64 {
+	if current.IsNull() {
+		return current
+	}
+	return int64Value(value)
+}
+
+// applyRunLimit sets the run_limit_* attributes on a marking ticket. Unset attributes clear the limit.
+func (model *challengeResourceModel) applyRunLimit(run_limit *resourceclients.RunLimitConfig) {
+	run_limit.CpuTime = optionalInt64(model.RunLimitCpuTimeMS)
+	run_limit.WallTime = optionalInt64(model.RunLimitWallTimeMS)
+	run_limit.Memory = optionalInt64(model.RunLimitMemoryBytes)
+	run_limit.OutputSize = optionalInt64(model.RunLimitOutputSizeBytes)
+	run_limit.Processes = optionalInt64(model.RunLimitProcesses)
+}
+
 func (model *challengeResourceModel) MapAPIObj(ctx context.Context, client *client.Client) (*resourceclients.Challenge, *resourceclients.Rubric, error) {
 
 	lesson_id := model.LessonId.ValueInt64()
@@ -404,6 +1129,9 @@ func (model *challengeResourceModel) MapAPIObj(ctx context.Context, client *clie
 	chal.Settings.Passback.ScoringMode = model.PassbackScoringMode.ValueString()
 	chal.Settings.Passback.ScaleTo = model.PassbackScaleTo.ValueFloat64()
 	chal.Settings.PerTestCaseScores = model.PerTestcaseScores.ValueBool()
+	chal.Settings.PointLossThreshold = int(model.PointLossThreshold.ValueInt64())
+	chal.Settings.PointLossEvery = int(model.PointLossEvery.ValueInt64())
+	chal.Settings.PointLossAmount = int(model.PointLossAmount.ValueInt64())
 
 	chal.Tickets.MarkUnit.BuildCommand = model.BuildCommand.ValueString()
 	chal.Tickets.MarkCustom.BuildCommand = model.BuildCommand.ValueString()
@@ -431,13 +1159,20 @@ func (model *challengeResourceModel) MapAPIObj(ctx context.Context, client *clie
 		chal.Tickets.MarkStandard.Overlay = model.TestcaseOverlayTestFiles.ValueBool()
 	} else if chal.Type == "custom" {
 		chal.Tickets.MarkCustom.RunCommand = model.CustomRunCommand.ValueString()
-		if !model.CustomMarkTimeLimitMS.IsNull() {
-			chal.Tickets.MarkCustom.RunLimit.CpuTime.Set(model.CustomMarkTimeLimitMS.ValueInt64())
-			chal.Tickets.MarkCustom.RunLimit.WallTime.Set(model.CustomMarkTimeLimitMS.ValueInt64())
+	}
+
+	if run_limit := chal.MarkRunLimit(); run_limit != nil {
+		model.applyRunLimit(run_limit)
+		if chal.Type == "custom" && !model.CustomMarkTimeLimitMS.IsNull() {
+			run_limit.CpuTime.Set(model.CustomMarkTimeLimitMS.ValueInt64())
+			run_limit.WallTime.Set(model.CustomMarkTimeLimitMS.ValueInt64())
 		}
 	}
 
-	if !model.Criteria.IsNull() {
+	if model.CriteriaToRubric.ValueBool() {
+		// The criteria were converted into the planned rubric.
+		chal.Settings.Criteria = []resourceclients.Criteria{}
+	} else if !model.Criteria.IsNull() {
 		var crit []resourceclients.Criteria
 		err = json.NewDecoder(strings.NewReader(model.Criteria.ValueString())).Decode(&crit)
 		if err != nil {
@@ -490,7 +1225,44 @@ func (r *challengeResource) Create(ctx context.Context, req resource.CreateReque
 		return
 	}
 
-	resourceclients.UpdateChallenge(r.client, plan.FolderPath.ValueString(), api_obj, rubric)
+	local, diags := plan.localChallenge(ctx)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	file_hashes, err := resourceclients.UpdateChallenge(ctx, r.client, local, api_obj, rubric, nil)
+	if err != nil {
+		resp.Diagnostics.AddError(
+			"Error Creating Challenge Object",
+			fmt.Sprintf("Could not create Challenge for Slide ID %d: %s", plan.SlideId.ValueInt64(), err.Error()),
+		)
+		return
+	}
+
+	// Hash the workspaces as Ed stored them, so Read only reports changes made afterwards.
+	workspace_hash, err := wshelpers.RemoteWorkspaceHash(r.client, api_obj.Id, local.Rules)
+	if err != nil {
+		resp.Diagnostics.AddError(
+			"Error Creating Challenge Object",
+			fmt.Sprintf("Could not read back workspaces for Slide ID %d: %s", plan.SlideId.ValueInt64(), err.Error()),
+		)
+		return
+	}
+	plan.WorkspaceHash = types.StringValue(workspace_hash)
+
+	private_state, err := json.Marshal(challengePrivateState{
+		WorkspaceHash: workspace_hash,
+		Files:         file_hashes,
+	})
+	if err != nil {
+		resp.Diagnostics.AddError(
+			"Error Creating Challenge Object",
+			fmt.Sprintf("Could not save private state for Slide ID %d: %s", plan.SlideId.ValueInt64(), err.Error()),
+		)
+		return
+	}
+	resp.Diagnostics.Append(resp.Private.SetKey(ctx, challengePrivateStateKey, private_state)...)
 
 	diags = resp.State.Set(ctx, plan)
 	resp.Diagnostics.Append(diags...)
@@ -522,6 +1294,15 @@ func compareCriteria(crit1 []resourceclients.Criteria, crit2 []resourceclients.C
 	return true
 }
 
+func compareRunLimit(limit1 resourceclients.RunLimitConfig, limit2 resourceclients.RunLimitConfig) bool {
+	return limit1.CpuTime.OrElse(0) == limit2.CpuTime.OrElse(0) &&
+		limit1.WallTime.OrElse(0) == limit2.WallTime.OrElse(0) &&
+		limit1.Memory.OrElse(0) == limit2.Memory.OrElse(0) &&
+		limit1.OutputSize.OrElse(0) == limit2.OutputSize.OrElse(0) &&
+		limit1.Processes.OrElse(0) == limit2.Processes.OrElse(0) &&
+		limit1.Pty.OrElse(false) == limit2.Pty.OrElse(false)
+}
+
 func compareTestCase(tc1 []resourceclients.TestCase, tc2 []resourceclients.TestCase) bool {
 	if len(tc1) != len(tc2) {
 		return false
@@ -536,8 +1317,7 @@ func compareTestCase(tc1 []resourceclients.TestCase, tc2 []resourceclients.TestC
 			tc1[i].Score != tc2[i].Score ||
 			tc1[i].Skip != tc2[i].Skip ||
 			tc1[i].StdinPath != tc2[i].StdinPath ||
-			tc1[i].RunLimit.CpuTime.OrElse(0) != tc2[i].RunLimit.CpuTime.OrElse(0) ||
-			tc1[i].RunLimit.Pty.OrElse(false) != tc2[i].RunLimit.Pty.OrElse(false) {
+			!compareRunLimit(tc1[i].RunLimit, tc2[i].RunLimit) {
 			return false
 		}
 		if len(tc1[i].Checks) != len(tc2[i].Checks) {
@@ -563,6 +1343,57 @@ func compareTestCase(tc1 []resourceclients.TestCase, tc2 []resourceclients.TestC
 	return true
 }
 
+// rubricToMD converts the Ed formatted item titles of a rubric back to markdown.
+func rubricToMD(rubric *resourceclients.Rubric) *resourceclients.Rubric {
+	converted := *rubric
+	converted.Sections = make([]resourceclients.RubricSection, len(rubric.Sections))
+	for i, section := range rubric.Sections {
+		converted.Sections[i] = section
+		converted.Sections[i].Items = make([]resourceclients.RubricItem, len(section.Items))
+		for j, item := range section.Items {
+			converted.Sections[i].Items[j] = item
+			converted.Sections[i].Items[j].Title = md2ed.RenderEdToMD(item.Title, "", false)
+		}
+	}
+	converted.UnsectionedItems = make([]resourceclients.RubricItem, len(rubric.UnsectionedItems))
+	for i, item := range rubric.UnsectionedItems {
+		converted.UnsectionedItems[i] = item
+		converted.UnsectionedItems[i].Title = md2ed.RenderEdToMD(item.Title, "", false)
+	}
+	return &converted
+}
+
+func compareRubricItems(items1 []resourceclients.RubricItem, items2 []resourceclients.RubricItem) bool {
+	if len(items1) != len(items2) {
+		return false
+	}
+	for i := range items1 {
+		if items1[i].Points != items2[i].Points ||
+			items1[i].StaffDescription != items2[i].StaffDescription ||
+			strings.Join(strings.Fields(items1[i].Title), " ") != strings.Join(strings.Fields(items2[i].Title), " ") {
+			return false
+		}
+	}
+	return true
+}
+
+// compareRubric compares a rubric from the configuration (markdown titles) with one from Ed (Ed titles).
+func compareRubric(state_rubric *resourceclients.Rubric, remote_rubric *resourceclients.Rubric) bool {
+	remote := rubricToMD(remote_rubric)
+	if state_rubric.PositiveGrading != remote.PositiveGrading ||
+		len(state_rubric.Sections) != len(remote.Sections) {
+		return false
+	}
+	for i := range state_rubric.Sections {
+		if state_rubric.Sections[i].Title != remote.Sections[i].Title ||
+			state_rubric.Sections[i].MarkClamp.OrElse(0) != remote.Sections[i].MarkClamp.OrElse(0) ||
+			!compareRubricItems(state_rubric.Sections[i].Items, remote.Sections[i].Items) {
+			return false
+		}
+	}
+	return compareRubricItems(state_rubric.UnsectionedItems, remote.UnsectionedItems)
+}
+
 // Read refreshes the Terraform state with the latest data.
 func (r *challengeResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
 	// Retrieve values from state
@@ -573,7 +1404,7 @@ func (r *challengeResource) Read(ctx context.Context, req resource.ReadRequest,
 		return
 	}
 
-	challenge, _, err := resourceclients.GetChallengeAndRubric(r.client, int(state.LessonId.ValueInt64()), int(state.SlideId.ValueInt64()))
+	challenge, rubric, err := resourceclients.GetChallengeAndRubric(r.client, int(state.LessonId.ValueInt64()), int(state.SlideId.ValueInt64()))
 	if err != nil {
 		resp.Diagnostics.AddError(
 			"Error Reading Challenge Object",
@@ -592,32 +1423,56 @@ func (r *challengeResource) Read(ctx context.Context, req resource.ReadRequest,
 	state.Connect = types.BoolValue(challenge.Features.Connect)
 	var cur_state []resourceclients.Criteria
 	json.NewDecoder(strings.NewReader(state.Criteria.ValueString())).Decode(&cur_state)
-	if !compareCriteria(cur_state, challenge.Settings.Criteria) {
+	// Converted criteria are cleared in Ed, but stay in the state as the source of the rubric.
+	if !state.CriteriaToRubric.ValueBool() && (state.Criteria.IsNull() || !compareCriteria(cur_state, challenge.Settings.Criteria)) {
 		// Criteria are different, set the state.
-		crit, err := json.MarshalIndent(challenge.Settings.Criteria, "", "  ")
+		criteria := challenge.Settings.Criteria
+		if criteria == nil {
+			criteria = []resourceclients.Criteria{}
+		}
+		crit, err := json.MarshalIndent(criteria, "", "  ")
 		if err != nil {
 			resp.Diagnostics.AddError(
 				"Error Reading Criteria Object",
 				fmt.Sprintf("Could not read Criteria from Slide ID %d: %s", state.SlideId.ValueInt64(), err.Error()),
 			)
+			return
 		}
 		state.Criteria = types.StringValue(string(crit))
 	}
-	challenge.Tickets.MarkCustom.RunLimit.CpuTime.If(func(val int64) { state.CustomMarkTimeLimitMS = types.Int64Value(val) })
+	// Only the limits the configuration sets are read back, as Ed may fill in defaults for the others.
+	// The deprecated custom_mark_time_limit_ms shares its limits with the run_limit_*_time_ms attributes.
+	run_limit := challenge.MarkRunLimit()
+	if run_limit == nil {
+		run_limit = &resourceclients.RunLimitConfig{}
+	}
+	if challenge.Type != "custom" {
+		state.CustomMarkTimeLimitMS = types.Int64Null()
+	}
+	state.CustomMarkTimeLimitMS = configuredInt64(state.CustomMarkTimeLimitMS, run_limit.CpuTime)
+	state.RunLimitCpuTimeMS = configuredInt64(state.RunLimitCpuTimeMS, run_limit.CpuTime)
+	state.RunLimitWallTimeMS = configuredInt64(state.RunLimitWallTimeMS, run_limit.WallTime)
+	state.RunLimitMemoryBytes = configuredInt64(state.RunLimitMemoryBytes, run_limit.Memory)
+	state.RunLimitOutputSizeBytes = configuredInt64(state.RunLimitOutputSizeBytes, run_limit.OutputSize)
+	state.RunLimitProcesses = configuredInt64(state.RunLimitProcesses, run_limit.Processes)
 	state.CustomRunCommand = types.StringValue(challenge.Tickets.MarkCustom.RunCommand)
 	state.Editor = types.BoolValue(challenge.Features.Editor)
 	state.Explanation = types.StringValue(challenge.Explanation)
 	state.Feedback = types.BoolValue(challenge.Features.Feedback)
-	// TODO: Check the challenge folder contents and compare.
 	state.GitSubmission = types.BoolValue(challenge.Features.GitSubmission)
 	state.IntermediateFiles = types.BoolValue(challenge.Features.IntermediateFiles)
 	state.ManualCompletion = types.BoolValue(challenge.Features.ManualCompletion)
 	state.Mark = types.BoolValue(challenge.Features.Mark)
 	state.MaxSubmissionsPerInterval = types.Int64Value(int64(challenge.Settings.MaxSubmissionsPerInterval))
+	state.PointLossThreshold = types.Int64Value(int64(challenge.Settings.PointLossThreshold))
+	state.PointLossEvery = types.Int64Value(int64(challenge.Settings.PointLossEvery))
+	state.PointLossAmount = types.Int64Value(int64(challenge.Settings.PointLossAmount))
 	state.OnlyGitSubmission = types.BoolValue(challenge.Settings.OnlyGitSubmission)
 	state.PassbackMaxAutomaticScore = types.Float64Value(challenge.Settings.Passback.MaxAutomaticScore)
 	state.PassbackScaleTo = types.Float64Value(challenge.Settings.Passback.ScaleTo)
-	state.PassbackScoringMode = types.StringValue(challenge.Settings.Passback.ScoringMode)
+	if challenge.Settings.Passback.ScoringMode != "" || !state.PassbackScoringMode.IsNull() {
+		state.PassbackScoringMode = types.StringValue(challenge.Settings.Passback.ScoringMode)
+	}
 	state.PerTestcaseScores = types.BoolValue(challenge.Settings.PerTestCaseScores)
 	state.RemoteDesktop = types.BoolValue(challenge.Features.RemoteDesktop)
 	state.Run = types.BoolValue(challenge.Features.Run)
@@ -628,23 +1483,70 @@ func (r *challengeResource) Read(ctx context.Context, req resource.ReadRequest,
 	state.Terminal = types.BoolValue(challenge.Features.Terminal)
 	var cur_tests []resourceclients.TestCase
 	json.NewDecoder(strings.NewReader(state.TestcaseJSON.ValueString())).Decode(&cur_tests)
-	if !compareTestCase(cur_tests, challenge.Tickets.MarkStandard.Testcases) {
+	if state.TestcaseJSON.IsNull() || !compareTestCase(cur_tests, challenge.Tickets.MarkStandard.Testcases) {
 		// Mismatching test case data.
-		testcase, err := json.MarshalIndent(challenge.Tickets.MarkStandard.Testcases, "", "  ")
+		testcases := challenge.Tickets.MarkStandard.Testcases
+		if testcases == nil {
+			testcases = []resourceclients.TestCase{}
+		}
+		testcase, err := json.MarshalIndent(testcases, "", "  ")
 		if err != nil {
 			resp.Diagnostics.AddError(
 				"Error Reading Testcases Object",
 				fmt.Sprintf("Could not read Test cases from Slide ID %d: %s", state.SlideId.ValueInt64(), err.Error()),
 			)
+			return
 		}
 		state.TestcaseJSON = types.StringValue(string(testcase))
 	}
 	state.TestcaseEasy = types.BoolValue(challenge.Tickets.MarkStandard.Easy)
 	state.TestcaseMarkAll = types.BoolValue(challenge.Tickets.MarkStandard.MarkAll)
 	state.TestcaseOverlayTestFiles = types.BoolValue(challenge.Tickets.MarkStandard.Overlay)
-	challenge.Tickets.MarkStandard.RunLimit.Pty.If(func(val bool) { state.TestcasePty = types.BoolValue(val) })
+	state.TestcasePty = types.BoolValue(challenge.Tickets.MarkStandard.RunLimit.Pty.OrElse(false))
 	state.Type = types.StringValue(challenge.Type)
 
+	// Rubrics are only refreshed once they are managed by terraform, as reapplying
+	// an empty rubric over one made in Ed would remove feedback.
+	if state.Rubric.ValueString() != "{}" && state.Rubric.ValueString() != "" && rubric != nil {
+		var cur_rubric resourceclients.Rubric
+		json.NewDecoder(strings.NewReader(state.Rubric.ValueString())).Decode(&cur_rubric)
+		if !compareRubric(&cur_rubric, rubric) {
+			rubric_json, err := json.MarshalIndent(rubricToMD(rubric), "", "  ")
+			if err != nil {
+				resp.Diagnostics.AddError(
+					"Error Reading Rubric Object",
+					fmt.Sprintf("Could not read Rubric from Slide ID %d: %s", state.SlideId.ValueInt64(), err.Error()),
+				)
+				return
+			}
+			state.Rubric = types.StringValue(string(rubric_json))
+		}
+	}
+	if !state.RubricPoints.IsNull() || challenge.RubricPoints.Present() {
+		state.RubricPoints = types.Int64Null()
+		challenge.RubricPoints.If(func(val int) { state.RubricPoints = types.Int64Value(int64(val)) })
+	}
+
+	// Workspaces are compared with what was last seen in Ed. On a change, folder_hash no longer
+	// matches the planned hash of the local folder so it is uploaded again.
+	local, diags := state.localChallenge(ctx)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	workspace_hash, err := wshelpers.RemoteWorkspaceHash(r.client, challenge.Id, local.Rules)
+	if err != nil {
+		resp.Diagnostics.AddError(
+			"Error Reading Challenge Workspaces",
+			fmt.Sprintf("Could not read workspaces from Slide ID %d: %s", state.SlideId.ValueInt64(), err.Error()),
+		)
+		return
+	}
+	if !state.WorkspaceHash.IsNull() && state.WorkspaceHash.ValueString() != workspace_hash {
+		state.FolderHash = types.StringValue(workspace_hash)
+	}
+	state.WorkspaceHash = types.StringValue(workspace_hash)
+
 	diags = resp.State.Set(ctx, &state)
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
@@ -671,7 +1573,68 @@ func (r *challengeResource) Update(ctx context.Context, req resource.UpdateReque
 		return
 	}
 
-	resourceclients.UpdateChallenge(r.client, plan.FolderPath.ValueString(), api_obj, rubric)
+	// The recorded file hashes only describe the workspaces if nobody has edited them in Ed since.
+	var state challengeResourceModel
+	diags = req.State.Get(ctx, &state)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	var applied challengePrivateState
+	applied_bytes, private_diags := req.Private.GetKey(ctx, challengePrivateStateKey)
+	resp.Diagnostics.Append(private_diags...)
+	if applied_bytes != nil {
+		err = json.Unmarshal(applied_bytes, &applied)
+		if err != nil {
+			resp.Diagnostics.AddError(
+				"Error Updating Challenge Object",
+				fmt.Sprintf("Could not read private state for Slide ID %d: %s", plan.SlideId.ValueInt64(), err.Error()),
+			)
+			return
+		}
+	}
+	if applied.WorkspaceHash != state.WorkspaceHash.ValueString() {
+		applied.Files = nil
+	}
+
+	local, diags := plan.localChallenge(ctx)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	file_hashes, err := resourceclients.UpdateChallenge(ctx, r.client, local, api_obj, rubric, applied.Files)
+	if err != nil {
+		resp.Diagnostics.AddError(
+			"Error Updating Challenge Object",
+			fmt.Sprintf("Could not update Challenge for Slide ID %d: %s", plan.SlideId.ValueInt64(), err.Error()),
+		)
+		return
+	}
+
+	// Hash the workspaces as Ed stored them, so Read only reports changes made afterwards.
+	workspace_hash, err := wshelpers.RemoteWorkspaceHash(r.client, api_obj.Id, local.Rules)
+	if err != nil {
+		resp.Diagnostics.AddError(
+			"Error Updating Challenge Object",
+			fmt.Sprintf("Could not read back workspaces for Slide ID %d: %s", plan.SlideId.ValueInt64(), err.Error()),
+		)
+		return
+	}
+	plan.WorkspaceHash = types.StringValue(workspace_hash)
+
+	private_state, err := json.Marshal(challengePrivateState{
+		WorkspaceHash: workspace_hash,
+		Files:         file_hashes,
+	})
+	if err != nil {
+		resp.Diagnostics.AddError(
+			"Error Updating Challenge Object",
+			fmt.Sprintf("Could not save private state for Slide ID %d: %s", plan.SlideId.ValueInt64(), err.Error()),
+		)
+		return
+	}
+	resp.Diagnostics.Append(resp.Private.SetKey(ctx, challengePrivateStateKey, private_state)...)
 
 	diags = resp.State.Set(ctx, plan)
 	resp.Diagnostics.Append(diags...)
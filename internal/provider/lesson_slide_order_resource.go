@@ -0,0 +1,218 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"terraform-provider-edstem/internal/client"
+	"terraform-provider-edstem/internal/resourceclients"
+
+	"github.com/hashicorp/terraform-plugin-framework-validators/listvalidator"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// Ensure the implementation satisfies the expected interfaces.
+var (
+	_ resource.Resource              = &lessonSlideOrderResource{}
+	_ resource.ResourceWithConfigure = &lessonSlideOrderResource{}
+)
+
+// NewLessonSlideOrderResource is a helper function to simplify the provider implementation.
+func NewLessonSlideOrderResource() resource.Resource {
+	return &lessonSlideOrderResource{}
+}
+
+// lessonSlideOrderResource puts the slides of a lesson in order. It is separate from edstem_lesson, as
+// it refers to the slides, which themselves refer to the lesson.
+type lessonSlideOrderResource struct {
+	client *client.Client
+}
+
+// Configure adds the provider configured client to the resource.
+func (r *lessonSlideOrderResource) Configure(_ context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+
+	client, ok := req.ProviderData.(*client.Client)
+
+	if !ok {
+		resp.Diagnostics.AddError(
+			"Unexpected Data Source Configure Type",
+			fmt.Sprintf("Expected *client.Client, got: %T. Please report this issue to the provider developers.", req.ProviderData),
+		)
+
+		return
+	}
+
+	r.client = client
+}
+
+// Metadata returns the resource type name.
+func (r *lessonSlideOrderResource) Metadata(_ context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_lesson_slide_order"
+}
+
+type lessonSlideOrderResourceModel struct {
+	LessonId    types.Int64  `tfsdk:"lesson_id"`
+	SlideIds    types.List   `tfsdk:"slide_ids"`
+	LastUpdated types.String `tfsdk:"last_updated"`
+}
+
+// Schema defines the schema for the resource.
+func (r *lessonSlideOrderResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "Puts the slides of a lesson in order. Slide ordering was first planned as a `slide_order` attribute of `edstem_lesson`, but the slides refer to the lesson, so the lesson can't also refer to the slides without a dependency cycle. It is a separate resource instead, applied after the slides. Deleting it leaves the slides in their current order.",
+		Attributes: map[string]schema.Attribute{
+			"lesson_id": schema.Int64Attribute{
+				Required: true,
+				PlanModifiers: []planmodifier.Int64{
+					int64planmodifier.RequiresReplace(),
+				},
+				MarkdownDescription: "Integer ID identifying the Lesson whose slides are ordered.",
+			},
+			"slide_ids": schema.ListAttribute{
+				ElementType: types.Int64Type,
+				Required:    true,
+				Validators: []validator.List{
+					listvalidator.UniqueValues(),
+				},
+				MarkdownDescription: "IDs of every slide in the lesson, in the order they should appear. The slides are put in this order in one step, with the fewest reorder calls, and the order is checked afterwards. Every slide in the lesson must be listed once. Keep the `index` of each `edstem_slide` matching its position here, or the two will keep moving the slides back and forth.",
+			},
+			"last_updated": schema.StringAttribute{
+				Computed: true,
+			},
+		},
+	}
+}
+
+// slideIds reads the slide_ids attribute.
+func (model *lessonSlideOrderResourceModel) slideIds(ctx context.Context) ([]int, diag.Diagnostics) {
+	slide_ids := make([]int64, 0, len(model.SlideIds.Elements()))
+	diags := model.SlideIds.ElementsAs(ctx, &slide_ids, false)
+	order := make([]int, len(slide_ids))
+	for i, slide_id := range slide_ids {
+		order[i] = int(slide_id)
+	}
+	return order, diags
+}
+
+// reorder puts the slides of the lesson in the order of slide_ids.
+func (r *lessonSlideOrderResource) reorder(ctx context.Context, model *lessonSlideOrderResourceModel, diags *diag.Diagnostics) {
+	order, order_diags := model.slideIds(ctx)
+	diags.Append(order_diags...)
+	if order_diags.HasError() {
+		return
+	}
+	err := resourceclients.ReorderSlides(r.client, int(model.LessonId.ValueInt64()), order)
+	if err != nil {
+		diags.AddAttributeError(
+			path.Root("slide_ids"),
+			"Error Reordering Slides",
+			fmt.Sprintf("Could not reorder the slides of Lesson ID %d: %s", model.LessonId.ValueInt64(), err.Error()),
+		)
+	}
+}
+
+// Create creates the resource and sets the initial Terraform state.
+func (r *lessonSlideOrderResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	// Retrieve values from plan
+	var plan lessonSlideOrderResourceModel
+	diags := req.Plan.Get(ctx, &plan)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	r.reorder(ctx, &plan, &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	plan.LastUpdated = types.StringValue(time.Now().Format(time.RFC1123Z))
+
+	diags = resp.State.Set(ctx, plan)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+}
+
+// Read refreshes the Terraform state with the latest data.
+func (r *lessonSlideOrderResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	// Retrieve values from state
+	var state lessonSlideOrderResourceModel
+	diags := req.State.Get(ctx, &state)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// Slides moved, added or removed in Ed show up as a change to slide_ids.
+	slide_ids, err := resourceclients.GetSlideIds(r.client, int(state.LessonId.ValueInt64()))
+	if err != nil {
+		resp.Diagnostics.AddError(
+			"Error Reading Lesson Slides",
+			fmt.Sprintf("Could not read the slides of Lesson ID %d: %s", state.LessonId.ValueInt64(), err.Error()),
+		)
+		return
+	}
+	state.SlideIds, diags = types.ListValueFrom(ctx, types.Int64Type, slide_ids)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	diags = resp.State.Set(ctx, &state)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+}
+
+// Update updates the resource and sets the updated Terraform state on success.
+func (r *lessonSlideOrderResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	// Retrieve values from plan
+	var plan lessonSlideOrderResourceModel
+	diags := req.Plan.Get(ctx, &plan)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	r.reorder(ctx, &plan, &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	plan.LastUpdated = types.StringValue(time.Now().Format(time.RFC850))
+
+	diags = resp.State.Set(ctx, plan)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+}
+
+// Delete leaves the slides in their current order.
+func (r *lessonSlideOrderResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+}
+
+func (r *lessonSlideOrderResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
+	lesson_id, err := strconv.Atoi(req.ID)
+	if err != nil {
+		resp.Diagnostics.AddError(
+			"Unexpected Import Identifier",
+			fmt.Sprintf("Expected import identifier to be integer. Got: %q", req.ID),
+		)
+		return
+	}
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("lesson_id"), lesson_id)...)
+}
@@ -5,9 +5,12 @@ package provider
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"strings"
 
 	"terraform-provider-edstem/internal/client"
+	"terraform-provider-edstem/internal/wshelpers"
 
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
 	"github.com/hashicorp/terraform-plugin-framework/path"
@@ -38,8 +41,11 @@ func (p *edstemProvider) Metadata(_ context.Context, _ provider.MetadataRequest,
 }
 
 type edstemProviderModel struct {
-	CourseId types.String `tfsdk:"course_id"`
-	Token    types.String `tfsdk:"token"`
+	CourseId         types.String `tfsdk:"course_id"`
+	Token            types.String `tfsdk:"token"`
+	WorkspaceBackend types.String `tfsdk:"workspace_backend"`
+	// ExperimentalWorkspaceFSOps enables the symlink and chmod operations of the websocket backend.
+	ExperimentalWorkspaceFSOps types.Bool `tfsdk:"experimental_workspace_fsops"`
 }
 
 func (p *edstemProvider) Schema(_ context.Context, _ provider.SchemaRequest, resp *provider.SchemaResponse) {
@@ -52,6 +58,14 @@ func (p *edstemProvider) Schema(_ context.Context, _ provider.SchemaRequest, res
 				Optional:  true,
 				Sensitive: true,
 			},
+			"experimental_workspace_fsops": schema.BoolAttribute{
+				Optional:    true,
+				Description: "Upload symlinks and executable bits over the websocket backend, using workspace operations which are not confirmed to exist in Ed. Without this, a symlink to a file is uploaded as a copy of the file, with a warning, and files keep the mode Ed gives them. Can also be set with the EDSTEM_EXPERIMENTAL_WORKSPACE_FSOPS environment variable.",
+			},
+			"workspace_backend": schema.StringAttribute{
+				Optional:    true,
+				Description: fmt.Sprintf("How challenge workspaces are transferred, one of %s. Defaults to websocket, which edits files one at a time through the Ed editor connection, and can only transfer text files, so binary files such as images must be uploaded through Ed and ignored. experimental_rest uploads and downloads each workspace as an archive. It is experimental, as its `challenges/{id}/workspace/{repo}/archive` endpoints are not confirmed to exist in Ed.", strings.Join(wshelpers.WorkspaceBackends, ", ")),
+			},
 		},
 	}
 }
@@ -88,6 +102,8 @@ func (p *edstemProvider) Configure(ctx context.Context, req provider.ConfigureRe
 
 	course_id := os.Getenv("EDSTEM_COURSE_ID")
 	token := os.Getenv("EDSTEM_TOKEN")
+	workspace_backend := os.Getenv("EDSTEM_WORKSPACE_BACKEND")
+	experimental_fsops := os.Getenv("EDSTEM_EXPERIMENTAL_WORKSPACE_FSOPS") == "true"
 
 	if !config.CourseId.IsNull() {
 		course_id = config.CourseId.ValueString()
@@ -95,6 +111,12 @@ func (p *edstemProvider) Configure(ctx context.Context, req provider.ConfigureRe
 	if !config.Token.IsNull() {
 		token = config.Token.ValueString()
 	}
+	if !config.WorkspaceBackend.IsNull() {
+		workspace_backend = config.WorkspaceBackend.ValueString()
+	}
+	if !config.ExperimentalWorkspaceFSOps.IsNull() {
+		experimental_fsops = config.ExperimentalWorkspaceFSOps.ValueBool()
+	}
 
 	if course_id == "" {
 		resp.Diagnostics.AddAttributeError(
@@ -115,6 +137,23 @@ func (p *edstemProvider) Configure(ctx context.Context, req provider.ConfigureRe
 		)
 	}
 
+	if workspace_backend == "" {
+		workspace_backend = wshelpers.WorkspaceBackendWebsocket
+	}
+	valid_backend := false
+	for _, backend := range wshelpers.WorkspaceBackends {
+		if workspace_backend == backend {
+			valid_backend = true
+		}
+	}
+	if !valid_backend {
+		resp.Diagnostics.AddAttributeError(
+			path.Root("workspace_backend"),
+			"Invalid Edstem Workspace Backend",
+			fmt.Sprintf("The workspace backend %q is not supported. Use one of %s in the configuration or the EDSTEM_WORKSPACE_BACKEND environment variable.", workspace_backend, strings.Join(wshelpers.WorkspaceBackends, ", ")),
+		)
+	}
+
 	if resp.Diagnostics.HasError() {
 		return
 	}
@@ -129,6 +168,8 @@ func (p *edstemProvider) Configure(ctx context.Context, req provider.ConfigureRe
 		)
 		return
 	}
+	client.WorkspaceBackend = workspace_backend
+	client.ExperimentalWorkspaceFSOps = experimental_fsops
 
 	resp.DataSourceData = client
 	resp.ResourceData = client
@@ -145,6 +186,7 @@ func (p *edstemProvider) DataSources(_ context.Context) []func() datasource.Data
 func (p *edstemProvider) Resources(_ context.Context) []func() resource.Resource {
 	return []func() resource.Resource{
 		NewLessonResource,
+		NewLessonSlideOrderResource,
 		NewSlideResource,
 		NewQuestionResource,
 		NewChallengeResource,
@@ -2,13 +2,15 @@ package provider
 
 import (
 	"context"
-	"errors"
+	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 	"terraform-provider-edstem/internal/client"
-	"terraform-provider-edstem/internal/md2ed"
 	"terraform-provider-edstem/internal/resourceclients"
 
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/booldefault"
@@ -107,19 +109,24 @@ func (r *questionResource) Schema(_ context.Context, _ resource.SchemaRequest, r
 			"answers": schema.ListAttribute{
 				ElementType: types.StringType,
 				Optional:    true,
+				Computed:    true,
 			},
 			"content": schema.StringAttribute{
 				Optional: true,
+				Computed: true,
 			},
 			"explanation": schema.StringAttribute{
 				Optional: true,
+				Computed: true,
 			},
 			"solution": schema.ListAttribute{
 				ElementType: types.Int64Type,
 				Optional:    true,
+				Computed:    true,
 			},
 			"question_document_string": schema.StringAttribute{
 				Optional: true,
+				Computed: true,
 			},
 			"formatted": schema.BoolAttribute{
 				Default:  booldefault.StaticBool(true),
@@ -147,7 +154,7 @@ func (model *questionResourceModel) MapAPIObj(ctx context.Context) (*resourcecli
 
 	obj.Type = model.Type.ValueString()
 
-	if model.QuestionDocumentString.IsNull() {
+	if model.QuestionDocumentString.IsNull() || model.QuestionDocumentString.IsUnknown() {
 
 		tmp := make([]types.String, 0, len(model.Answers.Elements()))
 		model.Answers.ElementsAs(ctx, &tmp, false)
@@ -167,32 +174,9 @@ func (model *questionResourceModel) MapAPIObj(ctx context.Context) (*resourcecli
 		}
 	} else {
 
-		docstring := strings.ReplaceAll(model.QuestionDocumentString.ValueString(), "\r", "")
-		docstring = "\n!nothing\n" + docstring
-
-		split := strings.Split(docstring, "\n!")
-
-		answer_counter := 0
-
-		for i, s := range split {
-			if i <= 1 {
-				continue
-			}
-			sep := strings.SplitAfterN(s, "\n", 2)
-			if sep[0] == "content\n" {
-				obj.Content.Set(md2ed.RenderMDToEd(strings.TrimSpace(sep[1])))
-			} else if sep[0] == "explanation\n" {
-				obj.Explanation.Set(md2ed.RenderMDToEd(strings.TrimSpace(sep[1])))
-			} else if strings.HasPrefix(sep[0], "answer") {
-				answer_split := strings.Split(sep[0], "-")
-				obj.Answers = append(obj.Answers, md2ed.RenderMDToEd(strings.TrimSpace(sep[1])))
-				if len(answer_split) > 1 {
-					obj.Solution = append(obj.Solution, answer_counter)
-				}
-				answer_counter++
-			} else {
-				return nil, errors.New(fmt.Sprintf("Unmatched exclamation line: %s:%s", sep[0], sep[1]))
-			}
+		err := resourceclients.ParseQuestionDocumentString(model.QuestionDocumentString.ValueString(), &obj)
+		if err != nil {
+			return nil, err
 		}
 	}
 
@@ -202,6 +186,86 @@ func (model *questionResourceModel) MapAPIObj(ctx context.Context) (*resourcecli
 	return &obj, nil
 }
 
+// MapQuestionContent sets the answers, content, explanation and solution from the API object.
+// The document string is regenerated if it is not already known.
+func (model *questionResourceModel) MapQuestionContent(ctx context.Context, obj *resourceclients.Question) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	answers := obj.Answers
+	if answers == nil {
+		answers = []string{}
+	}
+	answer_list, list_diags := types.ListValueFrom(ctx, types.StringType, answers)
+	diags.Append(list_diags...)
+	model.Answers = answer_list
+
+	solution := make([]int64, len(obj.Solution))
+	for i := range obj.Solution {
+		solution[i] = int64(obj.Solution[i])
+	}
+	solution_list, list_diags := types.ListValueFrom(ctx, types.Int64Type, solution)
+	diags.Append(list_diags...)
+	model.Solution = solution_list
+
+	model.Content = types.StringValue(obj.Content.OrElse(""))
+	model.Explanation = types.StringValue(obj.Explanation.OrElse(""))
+
+	if model.QuestionDocumentString.IsNull() || model.QuestionDocumentString.IsUnknown() {
+		model.QuestionDocumentString = types.StringValue(resourceclients.QuestionDocumentString(obj))
+	}
+
+	return diags
+}
+
+// questionPrivateState records the question as Ed stored it after the last apply, so Read can tell remote
+// edits apart from Ed normalising the content it is sent.
+type questionPrivateState struct {
+	ContentSha string `json:"content_sha"`
+}
+
+const questionPrivateStateKey = "question"
+
+func questionContentSha(question *resourceclients.Question) string {
+	return resourceclients.ContentSha([]byte(resourceclients.QuestionDocumentString(question)))
+}
+
+// storedQuestionPrivateState reads the question back from Ed and encodes its private state.
+func (r *questionResource) storedQuestionPrivateState(question *resourceclients.Question) ([]byte, error) {
+	stored, err := resourceclients.GetQuestion(r.client, int(question.LessonSlideId), int(question.Id))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(questionPrivateState{ContentSha: questionContentSha(stored)})
+}
+
+func compareQuestionContent(ctx context.Context, model *questionResourceModel, question *resourceclients.Question) bool {
+	if model.Content.ValueString() != question.Content.OrElse("") ||
+		model.Explanation.ValueString() != question.Explanation.OrElse("") {
+		return false
+	}
+	var answers []string
+	model.Answers.ElementsAs(ctx, &answers, false)
+	if len(answers) != len(question.Answers) {
+		return false
+	}
+	for i := range answers {
+		if answers[i] != question.Answers[i] {
+			return false
+		}
+	}
+	var solution []int64
+	model.Solution.ElementsAs(ctx, &solution, false)
+	if len(solution) != len(question.Solution) {
+		return false
+	}
+	for i := range solution {
+		if solution[i] != int64(question.Solution[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 // Create creates the resource and sets the initial Terraform state.
 func (r *questionResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
 	// Retrieve values from plan
@@ -231,6 +295,16 @@ func (r *questionResource) Create(ctx context.Context, req resource.CreateReques
 	}
 
 	plan.Id = types.Int64Value(int64(api_obj.Id))
+	resp.Diagnostics.Append(plan.MapQuestionContent(ctx, api_obj)...)
+	private_state, err := r.storedQuestionPrivateState(api_obj)
+	if err != nil {
+		resp.Diagnostics.AddError(
+			"Error Creating Question Object",
+			fmt.Sprintf("Could not read back Question ID %d: %s", api_obj.Id, err.Error()),
+		)
+		return
+	}
+	resp.Diagnostics.Append(resp.Private.SetKey(ctx, questionPrivateStateKey, private_state)...)
 
 	diags = resp.State.Set(ctx, plan)
 	resp.Diagnostics.Append(diags...)
@@ -250,15 +324,47 @@ func (r *questionResource) Read(ctx context.Context, req resource.ReadRequest, r
 		return
 	}
 
-	_, err := resourceclients.GetQuestion(r.client, int(state.LessonSlideId.ValueInt64()), int(state.Id.ValueInt64()))
+	question, err := resourceclients.GetQuestion(r.client, int(state.LessonSlideId.ValueInt64()), int(state.Id.ValueInt64()))
 	if err != nil {
 		resp.Diagnostics.AddError(
 			"Error Reading Question Object",
 			fmt.Sprintf("Could not read Question ID %d: %s", state.Id.ValueInt64(), err.Error()),
 		)
+		return
 	}
 
-	// TODO: For now, nothing happens with the read elements. Should update state to confirm any changes necessary.
+	var applied questionPrivateState
+	applied_bytes, private_diags := req.Private.GetKey(ctx, questionPrivateStateKey)
+	resp.Diagnostics.Append(private_diags...)
+	if applied_bytes != nil {
+		err = json.Unmarshal(applied_bytes, &applied)
+		if err != nil {
+			resp.Diagnostics.AddError(
+				"Error Reading Question Private State",
+				fmt.Sprintf("Could not decode private state for Question ID %d: %s", state.Id.ValueInt64(), err.Error()),
+			)
+			return
+		}
+	}
+
+	question.Index.If(func(val int64) { state.Index = types.Int64Value(val) })
+	state.AutoPoints = types.Int64Value(question.AutoPoints)
+	state.Type = types.StringValue(question.Type)
+	state.Formatted = types.BoolValue(question.Formatted)
+	state.MultipleSelection = types.BoolValue(question.MultipleSelection)
+	// Ed may normalise the content it is sent, so compare against what it stored after the last apply.
+	// States written before the private state existed fall back to comparing the attributes.
+	var changed bool
+	if applied_bytes != nil {
+		changed = applied.ContentSha != questionContentSha(question)
+	} else {
+		changed = !compareQuestionContent(ctx, &state, question)
+	}
+	if state.QuestionDocumentString.IsNull() || changed {
+		// Content changed (or has just been imported), so the document string needs to be rebuilt.
+		state.QuestionDocumentString = types.StringNull()
+		resp.Diagnostics.Append(state.MapQuestionContent(ctx, question)...)
+	}
 
 	diags = resp.State.Set(ctx, &state)
 	resp.Diagnostics.Append(diags...)
@@ -283,17 +389,29 @@ func (r *questionResource) Update(ctx context.Context, req resource.UpdateReques
 			"Error Updating Question Object",
 			fmt.Sprintf("Could not update Question: %s", err.Error()),
 		)
+		return
 	}
 
 	err = resourceclients.UpdateMultichoiceQuestion(r.client, api_obj)
 	if err != nil {
 		resp.Diagnostics.AddError(
 			"Error Updating Question Object",
-			fmt.Sprintf("Could not update Lesson ID %d: %s", api_obj.Id, err.Error()),
+			fmt.Sprintf("Could not update Question ID %d: %s", api_obj.Id, err.Error()),
 		)
+		return
 	}
 
 	plan.Id = types.Int64Value(int64(api_obj.Id))
+	resp.Diagnostics.Append(plan.MapQuestionContent(ctx, api_obj)...)
+	private_state, err := r.storedQuestionPrivateState(api_obj)
+	if err != nil {
+		resp.Diagnostics.AddError(
+			"Error Updating Question Object",
+			fmt.Sprintf("Could not read back Question ID %d: %s", api_obj.Id, err.Error()),
+		)
+		return
+	}
+	resp.Diagnostics.Append(resp.Private.SetKey(ctx, questionPrivateStateKey, private_state)...)
 
 	diags = resp.State.Set(ctx, plan)
 	resp.Diagnostics.Append(diags...)
@@ -305,3 +423,32 @@ func (r *questionResource) Update(ctx context.Context, req resource.UpdateReques
 // Delete deletes the resource and removes the Terraform state on success.
 func (r *questionResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
 }
+
+func (r *questionResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
+	idParts := strings.Split(req.ID, ",")
+	if len(idParts) != 2 {
+		resp.Diagnostics.AddError(
+			"Unexpected Import Identifier",
+			fmt.Sprintf("Expected import identifier to be two comma separated integers: slide_id,question_id. Got: %q", req.ID),
+		)
+		return
+	}
+	slide_id, err := strconv.Atoi(idParts[0])
+	if err != nil {
+		resp.Diagnostics.AddError(
+			"Unexpected Import Identifier",
+			fmt.Sprintf("Expected import identifier to be two comma separated integers: slide_id,question_id. Got: %q", req.ID),
+		)
+		return
+	}
+	question_id, err := strconv.Atoi(idParts[1])
+	if err != nil {
+		resp.Diagnostics.AddError(
+			"Unexpected Import Identifier",
+			fmt.Sprintf("Expected import identifier to be two comma separated integers: slide_id,question_id. Got: %q", req.ID),
+		)
+		return
+	}
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("lesson_slide_id"), slide_id)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), question_id)...)
+}
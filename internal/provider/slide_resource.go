@@ -2,6 +2,7 @@ package provider
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
@@ -70,9 +71,85 @@ type slideResourceModel struct {
 	Content     types.String `tfsdk:"content"`
 	ContentType types.String `tfsdk:"content_type"`
 	FilePath    types.String `tfsdk:"file_path"`
+	FileSha     types.String `tfsdk:"file_sha"`
 	Url         types.String `tfsdk:"url"`
 }
 
+// slidePrivateState records the slide as Ed stored it after the last apply, so Read can tell remote edits
+// apart from differences introduced by converting between markdown and the Ed format, or by Ed normalising
+// the content it is sent.
+type slidePrivateState struct {
+	ContentSha string `json:"content_sha"`
+	FileUrl    string `json:"file_url"`
+}
+
+const slidePrivateStateKey = "slide"
+
+// slideContent is the field of the slide which holds the content attribute.
+func slideContent(slide *resourceclients.Slide) string {
+	if slide.Type == "html" {
+		return slide.Html.OrElse("")
+	}
+	return slide.Content
+}
+
+// storedSlidePrivateState reads the slide back from Ed and encodes its private state.
+func (r *slideResource) storedSlidePrivateState(slide *resourceclients.Slide) ([]byte, error) {
+	stored, err := resourceclients.GetSlide(r.client, slide.LessonId, slide.Id)
+	if err != nil {
+		return nil, err
+	}
+	return encodeSlidePrivateState(stored)
+}
+
+func encodeSlidePrivateState(slide *resourceclients.Slide) ([]byte, error) {
+	return json.Marshal(slidePrivateState{
+		ContentSha: resourceclients.ContentSha([]byte(slideContent(slide))),
+		FileUrl:    slide.FileUrl.OrElse(""),
+	})
+}
+
+// slideFileShaModifier plans the sha of the local file for pdf slides, so replacing the file triggers an update.
+type slideFileShaModifier struct{}
+
+func (m slideFileShaModifier) Description(_ context.Context) string {
+	return "Computes the sha1 of the file at file_path for pdf slides."
+}
+
+func (m slideFileShaModifier) MarkdownDescription(ctx context.Context) string {
+	return m.Description(ctx)
+}
+
+func (m slideFileShaModifier) PlanModifyString(ctx context.Context, req planmodifier.StringRequest, resp *planmodifier.StringResponse) {
+	if req.Plan.Raw.IsNull() {
+		return
+	}
+	var slide_type, file_path types.String
+	resp.Diagnostics.Append(req.Plan.GetAttribute(ctx, path.Root("type"), &slide_type)...)
+	resp.Diagnostics.Append(req.Plan.GetAttribute(ctx, path.Root("file_path"), &file_path)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if slide_type.IsUnknown() || file_path.IsUnknown() {
+		resp.PlanValue = types.StringUnknown()
+		return
+	}
+	if slide_type.ValueString() != "pdf" || file_path.ValueString() == "" {
+		resp.PlanValue = types.StringValue("")
+		return
+	}
+	sha, err := resourceclients.LocalFileSha(file_path.ValueString())
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(
+			path.Root("file_path"),
+			"Error Reading Slide File",
+			fmt.Sprintf("Could not read %s: %s", file_path.ValueString(), err.Error()),
+		)
+		return
+	}
+	resp.PlanValue = types.StringValue(sha)
+}
+
 // Schema defines the schema for the resource.
 func (r *slideResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
 	resp.Schema = schema.Schema{
@@ -125,6 +202,13 @@ func (r *slideResource) Schema(_ context.Context, _ resource.SchemaRequest, resp
 				Default:             stringdefault.StaticString(""),
 				MarkdownDescription: "The path for certain slide types to load content (like `video` or `pdf`)",
 			},
+			"file_sha": schema.StringAttribute{
+				Computed: true,
+				PlanModifiers: []planmodifier.String{
+					slideFileShaModifier{},
+				},
+				MarkdownDescription: "SHA1 checksum of the file uploaded for `pdf` slides. Computed from `file_path`, and refreshed from Ed so that a replaced file is detected.",
+			},
 			"url": schema.StringAttribute{
 				Optional:            true,
 				MarkdownDescription: "The path for webpage slides to load from.",
@@ -189,6 +273,15 @@ func (r *slideResource) Create(ctx context.Context, req resource.CreateRequest,
 	}
 
 	plan.Id = types.Int64Value(int64(api_obj.Id))
+	private_state, err := r.storedSlidePrivateState(api_obj)
+	if err != nil {
+		resp.Diagnostics.AddError(
+			"Error Creating Slide Object",
+			fmt.Sprintf("Could not read back Slide ID %d: %s", api_obj.Id, err.Error()),
+		)
+		return
+	}
+	resp.Diagnostics.Append(resp.Private.SetKey(ctx, slidePrivateStateKey, private_state)...)
 
 	diags = resp.State.Set(ctx, plan)
 	resp.Diagnostics.Append(diags...)
@@ -217,10 +310,68 @@ func (r *slideResource) Read(ctx context.Context, req resource.ReadRequest, resp
 		return
 	}
 
-	state.Content = types.StringValue(slide.Content)
-	if state.ContentType.ValueString() == "md" {
-		state.Content = types.StringValue(md2ed.RenderEdToMD(state.Content.ValueString(), "", false))
+	var applied slidePrivateState
+	applied_bytes, private_diags := req.Private.GetKey(ctx, slidePrivateStateKey)
+	resp.Diagnostics.Append(private_diags...)
+	if applied_bytes != nil {
+		err = json.Unmarshal(applied_bytes, &applied)
+		if err != nil {
+			resp.Diagnostics.AddError(
+				"Error Reading Slide Private State",
+				fmt.Sprintf("Could not decode private state for Slide ID %d: %s", state.Id.ValueInt64(), err.Error()),
+			)
+			return
+		}
+	}
+
+	state.IsHidden = types.BoolValue(slide.IsHidden)
+	state.Title = types.StringValue(slide.Title)
+	state.Type = types.StringValue(slide.Type)
+	if state.ContentType.IsNull() {
+		// Imported slides are exported as markdown.
+		state.ContentType = types.StringValue("md")
+	}
+
+	// Converting markdown to the Ed format is lossy, so only replace the content
+	// when it differs from what was last applied.
+	remote_content := slideContent(slide)
+	if applied_bytes == nil || applied.ContentSha != resourceclients.ContentSha([]byte(remote_content)) {
+		if slide.Type != "html" && state.ContentType.ValueString() == "md" {
+			state.Content = types.StringValue(md2ed.RenderEdToMD(remote_content, "", false))
+		} else {
+			state.Content = types.StringValue(remote_content)
+		}
+	}
+
+	if slide.Type == "video" {
+		state.Url = types.StringNull()
+		slide.VideoUrl.If(func(val string) { state.Url = types.StringValue(val) })
+	} else if slide.Type == "webpage" {
+		state.Url = types.StringNull()
+		slide.Url.If(func(val string) { state.Url = types.StringValue(val) })
+	}
+
+	if state.FilePath.IsNull() {
+		state.FilePath = types.StringValue("")
 	}
+	if slide.Type != "pdf" {
+		state.FileSha = types.StringValue("")
+	} else if applied_bytes == nil || applied.FileUrl != slide.FileUrl.OrElse("") {
+		// The file was replaced in Ed (or the slide was imported), so hash what is there now.
+		file_sha := ""
+		if slide.FileUrl.OrElse("") != "" {
+			file_sha, err = resourceclients.RemoteFileSha(r.client, slide.FileUrl.MustGet())
+			if err != nil {
+				resp.Diagnostics.AddError(
+					"Error Reading Slide File",
+					fmt.Sprintf("Could not download file for Slide ID %d: %s", state.Id.ValueInt64(), err.Error()),
+				)
+				return
+			}
+		}
+		state.FileSha = types.StringValue(file_sha)
+	}
+
 	// The index reported by the slide endpoint is wrong.
 	// Should infer from the ordering in the lesson response instead.
 	slide_ids, err := resourceclients.GetSlideIds(r.client, int(state.LessonId.ValueInt64()))
@@ -229,6 +380,7 @@ func (r *slideResource) Read(ctx context.Context, req resource.ReadRequest, resp
 			"Error Reading Slide Indexes",
 			fmt.Sprintf("Could not read Lesson ID %d: %s", state.LessonId.ValueInt64(), err.Error()),
 		)
+		return
 	}
 	for index, slide_id := range slide_ids {
 		if slide_id == int(state.Id.ValueInt64()) {
@@ -236,17 +388,6 @@ func (r *slideResource) Read(ctx context.Context, req resource.ReadRequest, resp
 		}
 	}
 
-	state.IsHidden = types.BoolValue(slide.IsHidden)
-	state.Title = types.StringValue(slide.Title)
-	state.Type = types.StringValue(slide.Type)
-	if slide.Type == "html" {
-		slide.Html.If(func(val string) { state.Content = types.StringValue(val) })
-	} else if slide.Type == "video" {
-		slide.VideoUrl.If(func(val string) { state.Url = types.StringValue(val) })
-	} else if slide.Type == "webpage" {
-		slide.Url.If(func(val string) { state.Url = types.StringValue(val) })
-	}
-
 	diags = resp.State.Set(ctx, &state)
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
@@ -281,6 +422,15 @@ func (r *slideResource) Update(ctx context.Context, req resource.UpdateRequest,
 	}
 
 	plan.Id = types.Int64Value(int64(api_obj.Id))
+	private_state, err := r.storedSlidePrivateState(api_obj)
+	if err != nil {
+		resp.Diagnostics.AddError(
+			"Error Updating Slide Object",
+			fmt.Sprintf("Could not read back Slide ID %d: %s", api_obj.Id, err.Error()),
+		)
+		return
+	}
+	resp.Diagnostics.Append(resp.Private.SetKey(ctx, slidePrivateStateKey, private_state)...)
 
 	diags = resp.State.Set(ctx, plan)
 	resp.Diagnostics.Append(diags...)
@@ -2,10 +2,11 @@ package resourceclients
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"os"
 	"path"
+	"strings"
 
 	"terraform-provider-edstem/internal/client"
 	"terraform-provider-edstem/internal/md2ed"
@@ -88,6 +89,9 @@ type ChallengeSettings struct {
 	MaxSubmissionsWithIntermediateFiles int              `json:"max_submissions_with_intermediate_files"`
 	Passback                            PassbackSettings `json:"passback"`
 	PerTestCaseScores                   bool             `json:"per_testcase_scores"`
+	PointLossThreshold                  int              `json:"point_loss_threshold"`
+	PointLossEvery                      int              `json:"point_loss_every"`
+	PointLossAmount                     int              `json:"point_loss_amount"`
 	Criteria                            []Criteria       `json:"criteria"`
 }
 
@@ -129,10 +133,14 @@ type MarkCustomTicket struct {
 	RunLimit     RunLimitConfig `json:"run_limit"`
 }
 
+// RunLimitConfig limits a marking run. Times are in milliseconds and sizes in bytes. Unset limits use Ed's defaults.
 type RunLimitConfig struct {
-	CpuTime  optional.Int64 `json:"cpu_time"`
-	WallTime optional.Int64 `json:"wall_time"`
-	Pty      optional.Bool  `json:"pty"`
+	CpuTime    optional.Int64 `json:"cpu_time"`
+	WallTime   optional.Int64 `json:"wall_time"`
+	Memory     optional.Int64 `json:"memory"`
+	OutputSize optional.Int64 `json:"output_size"`
+	Processes  optional.Int64 `json:"processes"`
+	Pty        optional.Bool  `json:"pty"`
 }
 
 type MarkStandardTicket struct {
@@ -193,6 +201,27 @@ type TestCaseCheck struct {
 	*/
 }
 
+// MarkRunLimit returns the run_limit of the ticket that marks the challenge's type, or nil if the type has none.
+func (chal *Challenge) MarkRunLimit() *RunLimitConfig {
+	switch chal.Type {
+	case "code":
+		return &chal.Tickets.MarkStandard.RunLimit
+	case "custom":
+		return &chal.Tickets.MarkCustom.RunLimit
+	}
+	return nil
+}
+
+// TestcaseWorkspacePath converts a path from a testcase, which Ed accepts relative to the workspace or
+// under /home, to a path relative to the workspace root. ok is false if the path leaves the workspace.
+func TestcaseWorkspacePath(testcase_path string) (rel_path string, ok bool) {
+	rel_path = path.Clean(strings.TrimPrefix(testcase_path, "/home/"))
+	if rel_path == "." || rel_path == ".." || strings.HasPrefix(rel_path, "../") || path.IsAbs(rel_path) {
+		return rel_path, false
+	}
+	return rel_path, true
+}
+
 type PassbackSettings struct {
 	MaxAutomaticScore float64 `json:"max_automatic_score"`
 	ScaleTo           float64 `json:"scale_to"`
@@ -248,6 +277,9 @@ func GetChallengeAndRubric(c *client.Client, lesson_id int, slide_id int) (*Chal
 			var rubric *RubricResponse
 			resp.Challenge.RubricId.If(func(val int) {
 				body, err = c.HTTPRequest(fmt.Sprintf("rubrics/%d", val), "GET", bytes.Buffer{}, nil)
+				if err != nil {
+					return
+				}
 				rubric = &RubricResponse{}
 				err = json.NewDecoder(body).Decode(&rubric)
 			})
@@ -264,21 +296,51 @@ func GetChallengeAndRubric(c *client.Client, lesson_id int, slide_id int) (*Chal
 	return nil, nil, fmt.Errorf("Challenge for Slide %d Not Found", slide_id)
 }
 
-func UpdateChallenge(conn *client.Client, folder_path string, challenge *Challenge, rubric *Rubric) error {
-	dir_entries, err := os.ReadDir(folder_path)
+// UpdateChallenge syncs the scaffold, solution and testbase folders of local and saves the challenge
+// settings. Other folders, such as .git, are not workspaces and are left alone, as in WorkspaceHash,
+// and a workspace without a folder in any layer is not touched.
+// Paths matching the ignore patterns or a .edignore file are not uploaded. known_hashes holds the
+// file hashes returned by the previous sync, keyed by repo then path, and the hashes after
+// this sync are returned.
+func UpdateChallenge(ctx context.Context, conn *client.Client, local *wshelpers.LocalChallenge, challenge *Challenge, rubric *Rubric, known_hashes map[string]map[string]string) (map[string]map[string]string, error) {
+	// Every repo is attempted and the settings still saved, so one failing upload doesn't leave
+	// the rest of the challenge behind. The failures are reported together at the end.
+	file_hashes := make(map[string]map[string]string)
+	sync_errors := make([]error, 0)
+	for _, repo_name := range wshelpers.ChallengeRepos {
+		found, err := local.HasRepo(repo_name)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		repo_hashes, err := wshelpers.UpdateChallengeRepo(ctx, conn, challenge.Id, local, repo_name, known_hashes[repo_name])
+		if err != nil {
+			sync_errors = append(sync_errors, err)
+			continue
+		}
+		file_hashes[repo_name] = repo_hashes
+	}
+
+	err := SaveChallenge(conn, challenge, rubric)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for _, subdir := range dir_entries {
-		wshelpers.UpdateChallengeRepo(conn, challenge.Id, folder_path, subdir.Name())
+	if len(sync_errors) != 0 {
+		return file_hashes, &wshelpers.SyncError{Errors: sync_errors}
 	}
+	return file_hashes, nil
+}
 
+// SaveChallenge saves the challenge settings, then the rubric if one is given.
+func SaveChallenge(conn *client.Client, challenge *Challenge, rubric *Rubric) error {
 	var request = &ChallegeResponseJSON{}
 	request.Challenge = *challenge
 
 	buf := bytes.Buffer{}
-	err = json.NewEncoder(&buf).Encode(request)
+	err := json.NewEncoder(&buf).Encode(request)
 	if err != nil {
 		return err
 	}
@@ -324,7 +386,6 @@ func UpdateChallenge(conn *client.Client, folder_path string, challenge *Challen
 			}
 		}
 	}
-
 	return nil
 }
 
@@ -362,14 +423,16 @@ func ChallengeToTerraform(c *client.Client, lesson_id int, slide_id int, resourc
 	}
 
 	resource_string = resource_string + tfhelpers.TFProp("folder_path", folder_path, "")
-	resource_string = resource_string + tfhelpers.TFUnquote("folder_sha", fmt.Sprintf("sha1(join(\"\", [for f in fileset(path.cwd, \"%s/**\"): filesha1(\"${path.cwd}/${f}\")]))\n", folder_path))
 	resource_string = resource_string + tfhelpers.TFProp("type", chal.Type, "")
 
 	resource_string = resource_string + tfhelpers.TFProp("build_command", chal.Settings.BuildCommand, "")
 	resource_string = resource_string + tfhelpers.TFProp("run_command", chal.Settings.RunCommand, "")
 	resource_string = resource_string + tfhelpers.TFProp("test_command", chal.Settings.CheckCommand, "")
 	resource_string = resource_string + tfhelpers.TFProp("terminal_command", chal.Settings.TerminalCommand, "")
-	resource_string = resource_string + tfhelpers.TFProp("custom_run_command", chal.Tickets.MarkCustom.RunCommand, "")
+	// Type specific attributes are only exported for their type, as they are rejected on any other.
+	if chal.Type == "custom" {
+		resource_string = resource_string + tfhelpers.TFProp("custom_run_command", chal.Tickets.MarkCustom.RunCommand, "")
+	}
 
 	resource_string = resource_string + tfhelpers.TFProp("per_testcase_scores", chal.Settings.PerTestCaseScores, false)
 	resource_string = resource_string + tfhelpers.TFProp("max_submissions_per_interval", chal.Settings.MaxSubmissionsPerInterval, 0)
@@ -377,6 +440,10 @@ func ChallengeToTerraform(c *client.Client, lesson_id int, slide_id int, resourc
 	resource_string = resource_string + tfhelpers.TFProp("only_git_submission", chal.Settings.OnlyGitSubmission, false)
 	resource_string = resource_string + tfhelpers.TFProp("allow_submit_after_marking_limit", chal.Settings.AllowSubmitAfterMarkingLimit, false)
 
+	resource_string = resource_string + tfhelpers.TFProp("point_loss_threshold", chal.Settings.PointLossThreshold, 0)
+	resource_string = resource_string + tfhelpers.TFProp("point_loss_every", chal.Settings.PointLossEvery, 0)
+	resource_string = resource_string + tfhelpers.TFProp("point_loss_amount", chal.Settings.PointLossAmount, 0)
+
 	resource_string = resource_string + tfhelpers.TFProp("passback_scoring_mode", chal.Settings.Passback.ScoringMode, "")
 	resource_string = resource_string + tfhelpers.TFProp("passback_max_automatic_score", chal.Settings.Passback.MaxAutomaticScore, float64(0))
 	resource_string = resource_string + tfhelpers.TFProp("passback_scale_to", chal.Settings.Passback.ScaleTo, float64(0))
@@ -398,7 +465,13 @@ func ChallengeToTerraform(c *client.Client, lesson_id int, slide_id int, resourc
 	resource_string = resource_string + tfhelpers.TFProp("feature_remote_desktop", chal.Features.RemoteDesktop, false)
 	resource_string = resource_string + tfhelpers.TFProp("feature_intermediate_files", chal.Features.IntermediateFiles, false)
 
-	resource_string = resource_string + tfhelpers.TFProp("custom_mark_time_limit_ms", chal.Tickets.MarkCustom.RunLimit.CpuTime, optional.Int64{})
+	if run_limit := chal.MarkRunLimit(); run_limit != nil {
+		resource_string = resource_string + tfhelpers.TFProp("run_limit_cpu_time_ms", run_limit.CpuTime, optional.Int64{})
+		resource_string = resource_string + tfhelpers.TFProp("run_limit_wall_time_ms", run_limit.WallTime, optional.Int64{})
+		resource_string = resource_string + tfhelpers.TFProp("run_limit_memory_bytes", run_limit.Memory, optional.Int64{})
+		resource_string = resource_string + tfhelpers.TFProp("run_limit_output_size_bytes", run_limit.OutputSize, optional.Int64{})
+		resource_string = resource_string + tfhelpers.TFProp("run_limit_processes", run_limit.Processes, optional.Int64{})
+	}
 
 	if len(chal.Settings.Criteria) > 0 {
 		res, err := json.MarshalIndent(chal.Settings.Criteria, "", "  ")
@@ -417,13 +490,13 @@ func ChallengeToTerraform(c *client.Client, lesson_id int, slide_id int, resourc
 		resource_string = resource_string + tfhelpers.TFFile("rubric", string(res), content_path)
 	}
 
-	if len(chal.Tickets.MarkStandard.Testcases) > 0 {
+	if chal.Type == "code" && len(chal.Tickets.MarkStandard.Testcases) > 0 {
 		res, err := json.MarshalIndent(chal.Tickets.MarkStandard.Testcases, "", "  ")
 		if err != nil {
 			return "", []string{}, err
 		}
 		content_path := path.Join(folder_path, "testcases.json")
-		resource_string = resource_string + tfhelpers.TFFile("testcases", string(res), content_path)
+		resource_string = resource_string + tfhelpers.TFFile("testcase_json", string(res), content_path)
 	}
 
 	tfhelpers.TFProp("testcase_pty", chal.Tickets.MarkStandard.RunLimit.Pty, nil)
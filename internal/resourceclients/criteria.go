@@ -0,0 +1,105 @@
+package resourceclients
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"terraform-provider-edstem/internal/client"
+)
+
+// ErrNoCriteria is returned when there are no criteria to convert, as the empty rubric would replace
+// the criteria in Ed.
+var ErrNoCriteria = errors.New("no criteria to convert")
+
+// CriteriaToRubric converts the old criteria format to a rubric. Each criterion becomes a section
+// where a single level is selected, and each level an item whose title is the level's description
+// and whose points are its mark. Levels without a mark are worth 0 points.
+func CriteriaToRubric(criteria []Criteria) (*Rubric, error) {
+	if len(criteria) == 0 {
+		return nil, ErrNoCriteria
+	}
+	rubric := &Rubric{
+		PositiveGrading:  true,
+		Sections:         make([]RubricSection, 0, len(criteria)),
+		UnsectionedItems: []RubricItem{},
+	}
+	for i, criterion := range criteria {
+		section := RubricSection{
+			SelectOne: true,
+			Title:     criterion.Name,
+			Index:     i,
+			Items:     make([]RubricItem, 0, len(criterion.Levels)),
+		}
+		for j, level := range criterion.Levels {
+			points := 0
+			mark := strings.TrimSpace(level.Mark)
+			if mark != "" {
+				var err error
+				points, err = strconv.Atoi(mark)
+				if err != nil {
+					return nil, fmt.Errorf("Criterion %q level %q has mark %q, but rubric points must be whole numbers", criterion.Name, level.Description, level.Mark)
+				}
+			}
+			section.Items = append(section.Items, RubricItem{
+				Points: points,
+				Title:  level.Description,
+				Index:  j,
+			})
+		}
+		rubric.Sections = append(rubric.Sections, section)
+	}
+	return rubric, nil
+}
+
+// RubricMaxPoints is the most a submission can score on a rubric made by CriteriaToRubric,
+// taking the best item of each section.
+func RubricMaxPoints(rubric *Rubric) int {
+	total := 0
+	for _, section := range rubric.Sections {
+		best := 0
+		for _, item := range section.Items {
+			if item.Points > best {
+				best = item.Points
+			}
+		}
+		total += best
+	}
+	for _, item := range rubric.UnsectionedItems {
+		if item.Points > 0 {
+			total += item.Points
+		}
+	}
+	return total
+}
+
+// ConvertChallengeCriteria converts the criteria of the challenge on a slide to a rubric. Unless
+// dry_run is set the rubric is saved and the criteria cleared. Challenges which already have a rubric
+// are left alone, as replacing it would remove any feedback given with it.
+func ConvertChallengeCriteria(c *client.Client, lesson_id int, slide_id int, dry_run bool) (*Rubric, error) {
+	chal, _, err := GetChallengeAndRubric(c, lesson_id, slide_id)
+	if err != nil {
+		return nil, err
+	}
+	if len(chal.Settings.Criteria) == 0 {
+		return nil, fmt.Errorf("Challenge for Slide %d: %w", slide_id, ErrNoCriteria)
+	}
+	if chal.RubricId.Present() {
+		return nil, fmt.Errorf("Challenge for Slide %d already has a rubric", slide_id)
+	}
+
+	rubric, err := CriteriaToRubric(chal.Settings.Criteria)
+	if err != nil {
+		return nil, err
+	}
+	if dry_run {
+		return rubric, nil
+	}
+
+	chal.Settings.Criteria = []Criteria{}
+	err = SaveChallenge(c, chal, rubric)
+	if err != nil {
+		return nil, err
+	}
+	return rubric, nil
+}
@@ -4,7 +4,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"path"
+	"regexp"
+	"strings"
 	"terraform-provider-edstem/internal/client"
+	"terraform-provider-edstem/internal/md2ed"
+	"terraform-provider-edstem/internal/tfhelpers"
 
 	"github.com/markphelps/optional"
 )
@@ -63,7 +68,23 @@ type QuestionReadResponse struct {
 	Questions []MultiChoiceQuestionResponse `json:"questions"`
 }
 
-func GetQuestion(c *client.Client, lesson_slide_id int, question_id int) (*Question, error) {
+func questionFromResponse(response *MultiChoiceQuestionResponse) *Question {
+	var questionObj Question
+	questionObj.Id = response.Id
+	questionObj.Index = response.Index
+	questionObj.LessonSlideId = response.LessonSlideId
+	questionObj.AutoPoints = response.AutoPoints
+	questionObj.Type = response.Data.Type
+	questionObj.Answers = response.Data.Answers
+	questionObj.Content.Set(response.Data.Content)
+	questionObj.Explanation.Set(response.Data.Explanation)
+	questionObj.Solution = response.Data.Solution
+	questionObj.Formatted = response.Data.Formatted
+	questionObj.MultipleSelection = response.Data.MultipleSelection
+	return &questionObj
+}
+
+func GetQuestions(c *client.Client, lesson_slide_id int) ([]Question, error) {
 	body, err := c.HTTPRequest(fmt.Sprintf("lessons/slides/%d/questions", lesson_slide_id), "GET", bytes.Buffer{}, nil)
 	if err != nil {
 		return nil, err
@@ -73,16 +94,21 @@ func GetQuestion(c *client.Client, lesson_slide_id int, question_id int) (*Quest
 	if err != nil {
 		return nil, err
 	}
-	questions := resp.Questions
+	final := make([]Question, len(resp.Questions))
+	for i := range resp.Questions {
+		final[i] = *questionFromResponse(&resp.Questions[i])
+	}
+	return final, nil
+}
+
+func GetQuestion(c *client.Client, lesson_slide_id int, question_id int) (*Question, error) {
+	questions, err := GetQuestions(c, lesson_slide_id)
+	if err != nil {
+		return nil, err
+	}
 	for i := range questions {
 		if questions[i].Id == int64(question_id) {
-			var questionObj Question
-			tempVar, _ := json.Marshal(questions[i])
-			err = json.Unmarshal(tempVar, &questionObj)
-			if err != nil {
-				return nil, err
-			}
-			return &questionObj, nil
+			return &questions[i], nil
 		}
 	}
 	return nil, fmt.Errorf("Question ID %d Not Found", question_id)
@@ -152,3 +178,141 @@ func CreateQuestion(c *client.Client, question *Question) error {
 	question.Id = resp_lesson.Question.Id
 	return err
 }
+
+// questionDocumentHeader matches the lines which start each block of a question document, such as !content
+// or !answer-correct. Body lines that would match are escaped with a backslash.
+var questionDocumentHeader = regexp.MustCompile(`^!([a-z]+(?:-[a-z]+)*)[ \t]*$`)
+
+// escapedQuestionDocumentHeader matches a body line that looks like a header, with its escaping backslashes.
+var escapedQuestionDocumentHeader = regexp.MustCompile(`^\\*![a-z]+(?:-[a-z]+)*[ \t]*$`)
+
+// escapeQuestionDocumentBody adds a backslash to every line that could be read as a header. Lines that
+// were already escaped get another, so unescaping always gives back the original text.
+func escapeQuestionDocumentBody(body string) string {
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		if escapedQuestionDocumentHeader.MatchString(line) {
+			lines[i] = "\\" + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func unescapeQuestionDocumentBody(body string) string {
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "\\") && escapedQuestionDocumentHeader.MatchString(line) {
+			lines[i] = line[1:]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// questionContents lists the Ed documents of a question, in the order they appear in its document string.
+func questionContents(question *Question) []string {
+	contents := []string{question.Content.OrElse("")}
+	contents = append(contents, question.Answers...)
+	if question.Explanation.OrElse("") != "" {
+		contents = append(contents, question.Explanation.MustGet())
+	}
+	return contents
+}
+
+// SaveQuestionImages downloads the images of a question into folder_path, under the file names that
+// QuestionDocumentString refers to them by.
+func SaveQuestionImages(question *Question, folder_path string) {
+	for _, content := range questionContents(question) {
+		md2ed.RenderEdToMD(content, folder_path, true)
+	}
+}
+
+// QuestionDocumentString renders a question in the !content/!answer/!explanation
+// format accepted by the question_document_string attribute. Images are referred to by file name
+// only, so import_tf and Read produce the same document; SaveQuestionImages fetches the files.
+func QuestionDocumentString(question *Question) string {
+	render := func(content string) string {
+		return escapeQuestionDocumentBody(md2ed.RenderEdToMD(content, "", false))
+	}
+	blocks := make([]string, 0)
+	blocks = append(blocks, "!content\n\n"+render(question.Content.OrElse("")))
+	for i, answer := range question.Answers {
+		header := "!answer"
+		for _, solution := range question.Solution {
+			if solution == i {
+				header = "!answer-correct"
+			}
+		}
+		blocks = append(blocks, header+"\n"+render(answer))
+	}
+	if question.Explanation.OrElse("") != "" {
+		blocks = append(blocks, "!explanation\n\n"+render(question.Explanation.MustGet()))
+	}
+	return strings.Join(blocks, "\n\n") + "\n"
+}
+
+// ParseQuestionDocumentString sets the content, answers, solution and explanation of question from a
+// document made by QuestionDocumentString. Blocks start at lines which are only a header such as !content,
+// so markdown in the bodies, such as images, can't be mistaken for one. Text before the first header is ignored.
+func ParseQuestionDocumentString(document string, question *Question) error {
+	lines := strings.Split(strings.ReplaceAll(document, "\r", ""), "\n")
+	header := ""
+	body := make([]string, 0)
+	answer_counter := 0
+	flush := func() error {
+		content := md2ed.RenderMDToEd(strings.TrimSpace(unescapeQuestionDocumentBody(strings.Join(body, "\n"))))
+		switch {
+		case header == "":
+		case header == "content":
+			question.Content.Set(content)
+		case header == "explanation":
+			question.Explanation.Set(content)
+		case strings.HasPrefix(header, "answer"):
+			question.Answers = append(question.Answers, content)
+			if strings.Contains(header, "-") {
+				question.Solution = append(question.Solution, answer_counter)
+			}
+			answer_counter++
+		default:
+			return fmt.Errorf("Unmatched exclamation line: !%s", header)
+		}
+		return nil
+	}
+	for _, line := range lines {
+		match := questionDocumentHeader.FindStringSubmatch(line)
+		if match == nil {
+			body = append(body, line)
+			continue
+		}
+		err := flush()
+		if err != nil {
+			return err
+		}
+		header = match[1]
+		body = body[:0]
+	}
+	return flush()
+}
+
+func QuestionToTerraform(question *Question, resource_name string, folder_path string, slide_resource_name *string) (string, []string, error) {
+	resources := make([]string, 0)
+	resources = append(resources, fmt.Sprintf("edstem_question.%s %d,%d", resource_name, question.LessonSlideId, question.Id))
+
+	var resource_string = fmt.Sprintf("resource \"edstem_question\" %s {\n", resource_name)
+	if slide_resource_name != nil {
+		resource_string = resource_string + tfhelpers.TFUnquote("lesson_slide_id", fmt.Sprintf("edstem_slide.%s.id", *slide_resource_name))
+	} else {
+		resource_string = resource_string + tfhelpers.TFProp("lesson_slide_id", int(question.LessonSlideId), nil)
+	}
+	resource_string = resource_string + tfhelpers.TFProp("index", question.Index, nil)
+	resource_string = resource_string + tfhelpers.TFProp("type", question.Type, nil)
+	resource_string = resource_string + tfhelpers.TFProp("auto_points", int(question.AutoPoints), 1)
+	resource_string = resource_string + tfhelpers.TFProp("formatted", question.Formatted, true)
+	resource_string = resource_string + tfhelpers.TFProp("multiple_selection", question.MultipleSelection, false)
+
+	content_path := path.Join(folder_path, "question.md")
+	SaveQuestionImages(question, folder_path)
+	resource_string = resource_string + tfhelpers.TFFile("question_document_string", QuestionDocumentString(question), content_path)
+	resource_string = resource_string + "}"
+
+	return resource_string, resources, nil
+}
@@ -2,10 +2,15 @@ package resourceclients
 
 import (
 	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"path"
+	"sort"
 	"terraform-provider-edstem/internal/client"
 	"terraform-provider-edstem/internal/md2ed"
 	"terraform-provider-edstem/internal/tfhelpers"
@@ -113,6 +118,19 @@ func GetSlide(c *client.Client, lesson_id int, slide_id int) (*Slide, error) {
 	return nil, fmt.Errorf("Slide ID %d Not Found", slide_id)
 }
 
+func GetLessonSlides(c *client.Client, lesson_id int) ([]SlideResponse, error) {
+	body, err := c.HTTPRequest(fmt.Sprintf("lessons/%d?view=1", lesson_id), "GET", bytes.Buffer{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp := &LessonWithSlidesResponse{}
+	err = json.NewDecoder(body).Decode(resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Lesson.Slides, nil
+}
+
 func GetSlideIds(c *client.Client, lesson_id int) ([]int, error) {
 	body, err := c.HTTPRequest(fmt.Sprintf("lessons/%d?view=1", lesson_id), "GET", bytes.Buffer{}, nil)
 	if err != nil {
@@ -133,6 +151,106 @@ func GetSlideIds(c *client.Client, lesson_id int) ([]int, error) {
 	return final, nil
 }
 
+// SlideMove is a single reorder call, which moves SlideId to just before BeforeId, or to the end of
+// the lesson when BeforeId is 0.
+type SlideMove struct {
+	SlideId  int
+	BeforeId int
+}
+
+// PlanSlideOrder returns the fewest moves that put the slides in current into order. The longest run of
+// slides already in the right relative order is left in place and every other slide is moved once,
+// working back from the end so each slide is placed before one that is already settled.
+func PlanSlideOrder(current []int, order []int) ([]SlideMove, error) {
+	position := make(map[int]int, len(order))
+	for i, slide_id := range order {
+		if _, ok := position[slide_id]; ok {
+			return nil, fmt.Errorf("Slide ID %d is listed more than once", slide_id)
+		}
+		position[slide_id] = i
+	}
+	in_lesson := make(map[int]bool, len(current))
+	for _, slide_id := range current {
+		if _, ok := position[slide_id]; !ok {
+			return nil, fmt.Errorf("Slide ID %d is in the lesson but not in the slide order", slide_id)
+		}
+		in_lesson[slide_id] = true
+	}
+	for _, slide_id := range order {
+		if !in_lesson[slide_id] {
+			return nil, fmt.Errorf("Slide ID %d is not in the lesson", slide_id)
+		}
+	}
+
+	// Longest increasing subsequence of target positions, where tails[k] is the index in current of
+	// the smallest position ending a run of length k+1.
+	tails := make([]int, 0, len(current))
+	previous := make([]int, len(current))
+	for i, slide_id := range current {
+		k := sort.Search(len(tails), func(k int) bool { return position[current[tails[k]]] >= position[slide_id] })
+		previous[i] = -1
+		if k > 0 {
+			previous[i] = tails[k-1]
+		}
+		if k == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[k] = i
+		}
+	}
+	settled := make(map[int]bool, len(tails))
+	if len(tails) > 0 {
+		for i := tails[len(tails)-1]; i >= 0; i = previous[i] {
+			settled[current[i]] = true
+		}
+	}
+
+	moves := make([]SlideMove, 0, len(order)-len(settled))
+	for i := len(order) - 1; i >= 0; i-- {
+		if settled[order[i]] {
+			continue
+		}
+		before_id := 0
+		if i+1 < len(order) {
+			before_id = order[i+1]
+		}
+		moves = append(moves, SlideMove{SlideId: order[i], BeforeId: before_id})
+	}
+	return moves, nil
+}
+
+// ReorderSlides puts the slides of a lesson into order with the moves from PlanSlideOrder, then reads
+// the order back, as slides added or moved by someone else meanwhile would leave it different.
+func ReorderSlides(c *client.Client, lesson_id int, order []int) error {
+	current, err := GetSlideIds(c, lesson_id)
+	if err != nil {
+		return err
+	}
+	moves, err := PlanSlideOrder(current, order)
+	if err != nil {
+		return err
+	}
+	for _, move := range moves {
+		_, err := c.HTTPRequest(fmt.Sprintf("lessons/slides/%d/reorder/%d", move.SlideId, move.BeforeId), "PUT", bytes.Buffer{}, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	final, err := GetSlideIds(c, lesson_id)
+	if err != nil {
+		return err
+	}
+	same := len(final) == len(order)
+	for i := 0; same && i < len(final); i++ {
+		same = final[i] == order[i]
+	}
+	if !same {
+		return fmt.Errorf("Slides of Lesson ID %d are in order %v after reordering, expected %v", lesson_id, final, order)
+	}
+	return nil
+}
+
 func UpdateSlide(c *client.Client, slide *Slide) error {
 	request := &SlideUpdateRequest{}
 	request.Content = slide.Content
@@ -181,6 +299,7 @@ func UpdateSlide(c *client.Client, slide *Slide) error {
 		return err
 	}
 	slide.Id = resp_lesson.Slide.Id
+	slide.FileUrl = resp_lesson.Slide.FileUrl
 
 	// Reordering slides if necessary
 	slide_ids, err := GetSlideIds(c, slide.LessonId)
@@ -219,6 +338,56 @@ func UpdateSlide(c *client.Client, slide *Slide) error {
 	return nil
 }
 
+// ContentSha returns the hex encoded sha1 of some slide content, used to notice changes made in Ed.
+func ContentSha(content []byte) string {
+	sum := sha1.Sum(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func LocalFileSha(file_path string) (string, error) {
+	dat, err := os.ReadFile(file_path)
+	if err != nil {
+		return "", err
+	}
+	return ContentSha(dat), nil
+}
+
+// RemoteFileSha downloads a slide file with the client's timeout, and hashes it. The token is only sent
+// when the file is served from the Ed API host, as file urls may point at other storage.
+func RemoteFileSha(c *client.Client, url string) (string, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	if c.IsAPIHost(url) {
+		req.Header.Add("X-Token", c.Token)
+	}
+	http_client := *c.HTTPClient
+	http_client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		// Custom headers are copied to redirects, so drop the token when leaving the Ed API host.
+		if !c.IsAPIHost(req.URL.String()) {
+			req.Header.Del("X-Token")
+		}
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after 10 redirects downloading %s", url)
+		}
+		return nil
+	}
+	resp, err := http_client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("got a non 200 status code downloading %s: %v", url, resp.StatusCode)
+	}
+	dat, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return ContentSha(dat), nil
+}
+
 // The three lines
 //-----------------------------303367121714237365713833509663
 //-----------------------------303367121714237365713833509663
@@ -307,6 +476,24 @@ func SlideToTerraform(c *client.Client, lesson_id int, slide_id int, resource_na
 		}
 		resource_string = resource_string + "\n\n" + s
 		resources = append(resources, challenge_resources...)
+	} else if slide.Type == "quiz" {
+		questions, e := GetQuestions(c, slide_id)
+		if e != nil {
+			return "", []string{}, e
+		}
+		for i := range questions {
+			question_path := path.Join(folder_path, fmt.Sprintf("question_%d", i))
+			e = os.MkdirAll(question_path, 0777)
+			if e != nil {
+				return "", []string{}, e
+			}
+			s, question_resources, e := QuestionToTerraform(&questions[i], fmt.Sprintf("%s_question_%d", resource_name, i), question_path, &resource_name)
+			if e != nil {
+				return "", []string{}, e
+			}
+			resource_string = resource_string + "\n\n" + s
+			resources = append(resources, question_resources...)
+		}
 	}
 
 	return resource_string, resources, nil
@@ -0,0 +1,202 @@
+package resourceclients
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"terraform-provider-edstem/internal/wshelpers"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestCaseOverridesFiles are the sidecar files, in the challenge folder, that GenerateTestCases reads
+// overrides from. The first one found is used. They sit beside rather than in the testbase, so they are
+// never uploaded.
+var TestCaseOverridesFiles = []string{"testcase_overrides.yaml", "testcase_overrides.yml", "testcase_overrides.json"}
+
+// TestCaseOverride replaces the generated value of every field that is set.
+type TestCaseOverride struct {
+	Name        *string `json:"name" yaml:"name"`
+	Description *string `json:"description" yaml:"description"`
+	Score       *int    `json:"score" yaml:"score"`
+	MaxScore    *int    `json:"max_score" yaml:"max_score"`
+	Hidden      *bool   `json:"hidden" yaml:"hidden"`
+	Private     *bool   `json:"private" yaml:"private"`
+	Skip        *bool   `json:"skip" yaml:"skip"`
+	CheckType   *string `json:"check_type" yaml:"check_type"`
+}
+
+// TestCaseOverrides holds overrides for every generated testcase, and for single testcases keyed by
+// their path in the testbase without the extension, e.g. "1" or "hard/3".
+type TestCaseOverrides struct {
+	Defaults  TestCaseOverride            `json:"defaults" yaml:"defaults"`
+	Testcases map[string]TestCaseOverride `json:"testcases" yaml:"testcases"`
+}
+
+func (o TestCaseOverride) apply(testcase *TestCase) {
+	if o.Name != nil {
+		testcase.Name = *o.Name
+	}
+	if o.Description != nil {
+		testcase.Description = *o.Description
+	}
+	if o.Score != nil {
+		testcase.Score = *o.Score
+	}
+	if o.MaxScore != nil {
+		testcase.MaxScore = *o.MaxScore
+	}
+	if o.Hidden != nil {
+		testcase.Hidden = *o.Hidden
+	}
+	if o.Private != nil {
+		testcase.Private = *o.Private
+	}
+	if o.Skip != nil {
+		testcase.Skip = *o.Skip
+	}
+	if o.CheckType != nil {
+		for i := range testcase.Checks {
+			testcase.Checks[i].Type = *o.CheckType
+		}
+	}
+}
+
+// LoadTestCaseOverrides reads the first of TestCaseOverridesFiles in challenge_folder_path. Having none is not an error.
+func LoadTestCaseOverrides(challenge_folder_path string) (*TestCaseOverrides, error) {
+	overrides := &TestCaseOverrides{}
+	for _, file_name := range TestCaseOverridesFiles {
+		dat, err := os.ReadFile(path.Join(challenge_folder_path, file_name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(file_name, ".json") {
+			err = json.Unmarshal(dat, overrides)
+		} else {
+			decoder := yaml.NewDecoder(strings.NewReader(string(dat)))
+			decoder.KnownFields(true)
+			err = decoder.Decode(overrides)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse %s: %s", file_name, err.Error())
+		}
+		break
+	}
+	return overrides, nil
+}
+
+var testCaseFilePattern = regexp.MustCompile(`^(\d+)\.(in|out|args)$`)
+
+type testCaseFiles struct {
+	dir    string
+	number int
+	in     string
+	out    string
+	args   *string
+}
+
+func (f *testCaseFiles) key() string {
+	return path.Join(f.dir, strconv.Itoa(f.number))
+}
+
+// GenerateTestCases builds a testcase for every numbered N.in, N.out or N.args file in the merged testbase
+// of local. N.in is given as stdin, the output is diffed against N.out, and the contents of N.args are
+// appended to run_command. Testcases are ordered by folder then number, score 1 each, and can be adjusted
+// with a TestCaseOverridesFiles sidecar in local.FolderPath.
+func GenerateTestCases(local *wshelpers.LocalChallenge, run_command string) ([]TestCase, error) {
+	files, err := local.ReadRepo("testbase")
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := LoadTestCaseOverrides(local.FolderPath)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]*testCaseFiles)
+	for rel_path, file := range files {
+		if file.IsDir {
+			continue
+		}
+		match := testCaseFilePattern.FindStringSubmatch(path.Base(rel_path))
+		if match == nil {
+			continue
+		}
+		number, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, err
+		}
+		dir := path.Dir(rel_path)
+		if dir == "." {
+			dir = ""
+		}
+		tc_files := &testCaseFiles{dir: dir, number: number}
+		if existing, ok := found[tc_files.key()]; ok {
+			tc_files = existing
+		}
+		switch match[2] {
+		case "in":
+			tc_files.in = rel_path
+		case "out":
+			tc_files.out = rel_path
+		case "args":
+			args := strings.TrimSpace(string(file.Contents))
+			tc_files.args = &args
+		}
+		found[tc_files.key()] = tc_files
+	}
+
+	ordered := make([]*testCaseFiles, 0, len(found))
+	for _, tc_files := range found {
+		ordered = append(ordered, tc_files)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].dir != ordered[j].dir {
+			return ordered[i].dir < ordered[j].dir
+		}
+		return ordered[i].number < ordered[j].number
+	})
+
+	testcases := make([]TestCase, 0, len(ordered))
+	used := make(map[string]bool)
+	for _, tc_files := range ordered {
+		key := tc_files.key()
+		testcase := TestCase{
+			Name:        fmt.Sprintf("Case %s", key),
+			Score:       1,
+			StdinPath:   tc_files.in,
+			OutputFiles: []string{},
+			Checks:      []TestCaseCheck{},
+		}
+		if tc_files.out != "" {
+			testcase.Checks = append(testcase.Checks, TestCaseCheck{Type: "check_diff", ExpectPath: tc_files.out})
+		}
+		if tc_files.args != nil {
+			if run_command == "" {
+				return nil, fmt.Errorf("%s.args needs a run_command to pass the arguments to", key)
+			}
+			testcase.RunCommand.Set(strings.TrimSpace(fmt.Sprintf("%s %s", run_command, *tc_files.args)))
+		}
+		overrides.Defaults.apply(&testcase)
+		if override, ok := overrides.Testcases[key]; ok {
+			override.apply(&testcase)
+			used[key] = true
+		}
+		testcases = append(testcases, testcase)
+	}
+
+	for key := range overrides.Testcases {
+		if !used[key] {
+			return nil, fmt.Errorf("Testcase override %q does not match any N.in, N.out or N.args file in the testbase", key)
+		}
+	}
+	return testcases, nil
+}
@@ -0,0 +1,151 @@
+package resourceclients
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"terraform-provider-edstem/internal/client"
+	"terraform-provider-edstem/internal/wshelpers"
+)
+
+// WorkspaceManifestEntry records where a challenge's workspaces are stored in an export.
+// Each of Folder/scaffold, Folder/solution and Folder/testbase can be restored with
+// wshelpers.UpdateChallengeRepo, or used as the folder_path of an edstem_challenge.
+type WorkspaceManifestEntry struct {
+	LessonId    int    `json:"lesson_id"`
+	LessonTitle string `json:"lesson_title"`
+	SlideId     int    `json:"slide_id"`
+	SlideTitle  string `json:"slide_title"`
+	ChallengeId int    `json:"challenge_id"`
+	Folder      string `json:"folder"`
+	// Skipped lists the binary files left out of the archive, below Folder, as the websocket backend
+	// can't read them exactly.
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+type WorkspaceManifest struct {
+	CourseId   string                   `json:"course_id"`
+	Challenges []WorkspaceManifestEntry `json:"challenges"`
+}
+
+// WorkspaceManifestFile is the name of the manifest at the root of every export.
+const WorkspaceManifestFile = "manifest.json"
+
+// FindChallenges lists the challenges of a course. lesson_id and slide_id narrow it to a single lesson or slide.
+func FindChallenges(c *client.Client, lesson_id *int, slide_id *int) ([]WorkspaceManifestEntry, error) {
+	lessons, err := GetLessons(c)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]WorkspaceManifestEntry, 0)
+	for _, lesson := range lessons {
+		if lesson_id != nil && lesson.Id != *lesson_id {
+			continue
+		}
+		slides, err := GetLessonSlides(c, lesson.Id)
+		if err != nil {
+			return nil, err
+		}
+		for _, slide := range slides {
+			if slide_id != nil && slide.Id != *slide_id {
+				continue
+			}
+			if !slide.ChallengeId.Present() {
+				continue
+			}
+			entries = append(entries, WorkspaceManifestEntry{
+				LessonId:    lesson.Id,
+				LessonTitle: lesson.Title,
+				SlideId:     slide.Id,
+				SlideTitle:  slide.Title,
+				ChallengeId: slide.ChallengeId.MustGet(),
+			})
+		}
+	}
+	return entries, nil
+}
+
+// addChallengeWorkspaces reads every workspace of a challenge into files, below folder. Binary files are
+// skipped rather than archived corrupted, and recorded in the challenge's manifest entry.
+func addChallengeWorkspaces(c *client.Client, challenge *WorkspaceManifestEntry, folder string, files map[string]wshelpers.RepoFile) error {
+	for _, repo_name := range wshelpers.ChallengeRepos {
+		repo_files, err := wshelpers.ReadRepoFiles(c, challenge.ChallengeId, repo_name)
+		if err != nil {
+			return &wshelpers.PathError{Repo: repo_name, Err: err}
+		}
+		for _, rel_path := range wshelpers.SkipBinaryFiles(c, repo_name, repo_files) {
+			challenge.Skipped = append(challenge.Skipped, path.Join(repo_name, rel_path))
+		}
+		repo_folder := path.Join(folder, repo_name)
+		files[repo_folder] = wshelpers.RepoFile{IsDir: true}
+		for rel_path, file := range repo_files {
+			files[path.Join(repo_folder, rel_path)] = file
+		}
+	}
+	return nil
+}
+
+func writeWorkspaceArchive(file_path string, manifest WorkspaceManifest, files map[string]wshelpers.RepoFile) error {
+	manifest_json, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	files[WorkspaceManifestFile] = wshelpers.RepoFile{Mode: 0644, Contents: append(manifest_json, '\n')}
+
+	archive, err := wshelpers.ArchiveRepoFiles(files)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file_path, archive, 0666)
+}
+
+// ExportWorkspaces writes the scaffold, solution and testbase of the given challenges to tar.gz archives in
+// output_path, along with a manifest of their IDs. With per_course every challenge goes into a single
+// course_<course_id>.tar.gz, otherwise each gets its own challenge_<challenge_id>.tar.gz. The same workspaces
+// always produce byte-identical archives. Unless the experimental_rest backend is used, binary files are left
+// out and listed in the manifest. The paths of the written archives are returned.
+func ExportWorkspaces(c *client.Client, challenges []WorkspaceManifestEntry, output_path string, per_course bool) ([]string, error) {
+	err := os.MkdirAll(output_path, 0777)
+	if err != nil {
+		return nil, err
+	}
+
+	written := make([]string, 0)
+	if per_course {
+		manifest := WorkspaceManifest{CourseId: c.CourseID, Challenges: make([]WorkspaceManifestEntry, 0, len(challenges))}
+		files := make(map[string]wshelpers.RepoFile)
+		for _, challenge := range challenges {
+			challenge.Folder = fmt.Sprintf("lesson_%d/slide_%d", challenge.LessonId, challenge.SlideId)
+			err = addChallengeWorkspaces(c, &challenge, challenge.Folder, files)
+			if err != nil {
+				return written, fmt.Errorf("Could not export Slide %d: %s", challenge.SlideId, err.Error())
+			}
+			manifest.Challenges = append(manifest.Challenges, challenge)
+		}
+		archive_path := path.Join(output_path, fmt.Sprintf("course_%s.tar.gz", c.CourseID))
+		err = writeWorkspaceArchive(archive_path, manifest, files)
+		if err != nil {
+			return written, err
+		}
+		return append(written, archive_path), nil
+	}
+
+	for _, challenge := range challenges {
+		challenge.Folder = "."
+		files := make(map[string]wshelpers.RepoFile)
+		err = addChallengeWorkspaces(c, &challenge, "", files)
+		if err != nil {
+			return written, fmt.Errorf("Could not export Slide %d: %s", challenge.SlideId, err.Error())
+		}
+		manifest := WorkspaceManifest{CourseId: c.CourseID, Challenges: []WorkspaceManifestEntry{challenge}}
+		archive_path := path.Join(output_path, fmt.Sprintf("challenge_%d.tar.gz", challenge.ChallengeId))
+		err = writeWorkspaceArchive(archive_path, manifest, files)
+		if err != nil {
+			return written, err
+		}
+		written = append(written, archive_path)
+	}
+	return written, nil
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package testrunner
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// startProcessGroup runs cmd in a process group of its own, so everything it starts can be killed with it.
+func startProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills cmd and every process it started. Until they are all gone they can hold the
+// output pipe open, and cmd.Wait would not return.
+func killProcessGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
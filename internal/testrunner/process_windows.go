@@ -0,0 +1,15 @@
+//go:build windows
+
+package testrunner
+
+import (
+	"os/exec"
+)
+
+// startProcessGroup does nothing, as Windows has no process groups to kill at once.
+func startProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup only kills cmd itself.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,427 @@
+package testrunner
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"terraform-provider-edstem/internal/resourceclients"
+	"terraform-provider-edstem/internal/wshelpers"
+)
+
+// TestcasesFile is the default name of the testcases, in the resourceclients.TestCase format,
+// inside a challenge folder.
+const TestcasesFile = "testcases.json"
+
+// DefaultWallTime limits testcases which set no wall_time of their own.
+const DefaultWallTime = 10 * time.Second
+
+// Config describes how a challenge is built and run, as in the mark_standard ticket.
+type Config struct {
+	FolderPath string
+	// OverlayPaths are layered under FolderPath, as in the overlay_paths of an edstem_challenge.
+	OverlayPaths   []string
+	TestcasesPath  string
+	IgnorePatterns []string
+	// TemplateVars render the .tmpl files, as in the template_vars of an edstem_challenge.
+	TemplateVars map[string]string
+
+	BuildCommand string
+	RunCommand   string
+	// RunLimit applies to testcases without their own limits. Times are in milliseconds, as in Ed.
+	RunLimit resourceclients.RunLimitConfig
+}
+
+type CheckResult struct {
+	Name    string
+	Type    string
+	Passed  bool
+	Skipped bool
+	Message string
+}
+
+type TestcaseResult struct {
+	Name     string
+	Skipped  bool
+	Passed   bool
+	Score    int
+	MaxScore int
+	ExitCode int
+	TimedOut bool
+	// OutputExceeded is set when the run was stopped for going over the output_size limit.
+	OutputExceeded bool
+	Output         string
+	Checks         []CheckResult
+}
+
+// LoadTestcases reads a list of testcases, or a mark_standard ticket holding them.
+func LoadTestcases(testcases_path string) ([]resourceclients.TestCase, error) {
+	dat, err := os.ReadFile(testcases_path)
+	if err != nil {
+		return nil, err
+	}
+	testcases := make([]resourceclients.TestCase, 0)
+	err = json.Unmarshal(dat, &testcases)
+	if err == nil {
+		return testcases, nil
+	}
+	ticket := &resourceclients.MarkStandardTicket{}
+	if json.Unmarshal(dat, ticket) != nil {
+		return nil, err
+	}
+	return ticket.Testcases, nil
+}
+
+// prepareWorkspace lays the testbase over the solution in a new folder, as Ed does when marking a submission.
+func prepareWorkspace(config Config) (string, error) {
+	local, err := wshelpers.LoadLocalChallenge(config.FolderPath, config.OverlayPaths, config.IgnorePatterns)
+	if err != nil {
+		return "", err
+	}
+	local.TemplateVars = config.TemplateVars
+	workspace, err := os.MkdirTemp("", "edstem-testrunner-")
+	if err != nil {
+		return "", err
+	}
+	for _, repo_name := range []string{"solution", "testbase"} {
+		files, err := local.ReadRepo(repo_name)
+		if err != nil {
+			os.RemoveAll(workspace)
+			return "", err
+		}
+		err = wshelpers.WriteLocalRepoFiles(workspace, "", files)
+		if err != nil {
+			os.RemoveAll(workspace)
+			return "", err
+		}
+	}
+	return workspace, nil
+}
+
+// workspacePath resolves a path from a testcase within workspace.
+func workspacePath(workspace string, testcase_path string) (string, error) {
+	rel_path, ok := resourceclients.TestcaseWorkspacePath(testcase_path)
+	if !ok {
+		return "", fmt.Errorf("%s is outside the workspace", testcase_path)
+	}
+	return filepath.Join(workspace, filepath.FromSlash(rel_path)), nil
+}
+
+type runResult struct {
+	output    []byte
+	exit_code int
+	timed_out bool
+
+	output_exceeded bool
+}
+
+// run executes command with sh in workspace. CPU time and memory are limited with ulimit, while running past the
+// wall time or the output size kills the command along with everything it started. The process limit is not applied, as ulimit -u counts
+// every process of the user rather than those of the run.
+func run(workspace string, command string, stdin []byte, limit resourceclients.RunLimitConfig) (*runResult, error) {
+	wall_time := DefaultWallTime
+	limit.WallTime.If(func(val int64) {
+		if val > 0 {
+			wall_time = time.Duration(val) * time.Millisecond
+		}
+	})
+	limit.CpuTime.If(func(val int64) {
+		if val > 0 {
+			// ulimit only takes whole seconds, so round up.
+			command = fmt.Sprintf("ulimit -t %d\n%s", (val+999)/1000, command)
+		}
+	})
+
+	limit.Memory.If(func(val int64) {
+		if val > 0 {
+			command = fmt.Sprintf("ulimit -v %d\n%s", (val+1023)/1024, command)
+		}
+	})
+
+	// Ed checks stdout and stderr together by default.
+	output := &limitedOutput{exceeded: make(chan struct{})}
+	limit.OutputSize.If(func(val int64) {
+		if val > 0 {
+			output.limit = val
+		}
+	})
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = workspace
+	cmd.Env = append(os.Environ(), fmt.Sprintf("HOME=%s", workspace))
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	startProcessGroup(cmd)
+
+	err := cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	timer := time.NewTimer(wall_time)
+	defer timer.Stop()
+	timed_out := false
+	select {
+	case err = <-done:
+	case <-timer.C:
+		timed_out = true
+		killProcessGroup(cmd)
+		err = <-done
+	case <-output.exceeded:
+		killProcessGroup(cmd)
+		err = <-done
+	}
+
+	result := &runResult{output: output.Bytes(), output_exceeded: output.Exceeded()}
+	if timed_out {
+		result.timed_out = true
+		result.exit_code = -1
+		return result, nil
+	}
+	var exit_err *exec.ExitError
+	if errors.As(err, &exit_err) {
+		result.exit_code = exit_err.ExitCode()
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// limitedOutput collects the output of a run up to limit bytes, if limit is set. Going over it closes exceeded.
+type limitedOutput struct {
+	limit    int64
+	exceeded chan struct{}
+
+	lock     sync.Mutex
+	buf      bytes.Buffer
+	overflow bool
+}
+
+func (o *limitedOutput) Write(p []byte) (int, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.overflow {
+		return len(p), nil
+	}
+	if o.limit > 0 && int64(o.buf.Len()+len(p)) > o.limit {
+		o.buf.Write(p[:o.limit-int64(o.buf.Len())])
+		o.overflow = true
+		close(o.exceeded)
+		return len(p), nil
+	}
+	return o.buf.Write(p)
+}
+
+func (o *limitedOutput) Bytes() []byte {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.buf.Bytes()
+}
+
+func (o *limitedOutput) Exceeded() bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.overflow
+}
+
+func mergeRunLimit(testcase resourceclients.RunLimitConfig, fallback resourceclients.RunLimitConfig) resourceclients.RunLimitConfig {
+	if !testcase.CpuTime.Present() || testcase.CpuTime.MustGet() == 0 {
+		testcase.CpuTime = fallback.CpuTime
+	}
+	if !testcase.WallTime.Present() || testcase.WallTime.MustGet() == 0 {
+		testcase.WallTime = fallback.WallTime
+	}
+	if !testcase.Memory.Present() || testcase.Memory.MustGet() == 0 {
+		testcase.Memory = fallback.Memory
+	}
+	if !testcase.OutputSize.Present() || testcase.OutputSize.MustGet() == 0 {
+		testcase.OutputSize = fallback.OutputSize
+	}
+	return testcase
+}
+
+// normaliseOutput ignores trailing whitespace on each line and trailing blank lines, like Ed's default diff check.
+func normaliseOutput(output []byte) string {
+	lines := strings.Split(strings.ReplaceAll(string(output), "\r\n", "\n"), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " \t")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
+
+func runCheck(workspace string, check resourceclients.TestCaseCheck, result *runResult) CheckResult {
+	check_result := CheckResult{Name: check.Name, Type: check.Type}
+	switch check.Type {
+	case "check_diff":
+		expect_path, err := workspacePath(workspace, check.ExpectPath)
+		if err != nil {
+			check_result.Message = err.Error()
+			return check_result
+		}
+		expected, err := os.ReadFile(expect_path)
+		if err != nil {
+			check_result.Message = fmt.Sprintf("Could not read expected output: %s", err.Error())
+			return check_result
+		}
+		check_result.Passed = normaliseOutput(expected) == normaliseOutput(result.output)
+		if !check_result.Passed {
+			check_result.Message = fmt.Sprintf("Output does not match %s", check.ExpectPath)
+		}
+	default:
+		check_result.Skipped = true
+		check_result.Message = fmt.Sprintf("%s checks can't be run locally", check.Type)
+	}
+	return check_result
+}
+
+func runTestcase(workspace string, config Config, testcase resourceclients.TestCase) (*TestcaseResult, error) {
+	result := &TestcaseResult{Name: testcase.Name, MaxScore: testcase.Score}
+	if testcase.Skip {
+		result.Skipped = true
+		return result, nil
+	}
+
+	command := config.RunCommand
+	testcase.RunCommand.If(func(val string) {
+		if val != "" {
+			command = val
+		}
+	})
+	if command == "" {
+		return nil, fmt.Errorf("Testcase %s has no run_command, and none was given for the challenge", testcase.Name)
+	}
+
+	var stdin []byte
+	if testcase.StdinPath != "" {
+		stdin_path, err := workspacePath(workspace, testcase.StdinPath)
+		if err != nil {
+			return nil, fmt.Errorf("Testcase %s: %s", testcase.Name, err.Error())
+		}
+		dat, err := os.ReadFile(stdin_path)
+		if err != nil {
+			return nil, fmt.Errorf("Testcase %s: could not read stdin_path: %s", testcase.Name, err.Error())
+		}
+		stdin = dat
+	}
+
+	run_result, err := run(workspace, command, stdin, mergeRunLimit(testcase.RunLimit, config.RunLimit))
+	if err != nil {
+		return nil, fmt.Errorf("Testcase %s: %s", testcase.Name, err.Error())
+	}
+	result.ExitCode = run_result.exit_code
+	result.TimedOut = run_result.timed_out
+	result.OutputExceeded = run_result.output_exceeded
+	result.Output = string(run_result.output)
+
+	result.Passed = !run_result.timed_out && !run_result.output_exceeded
+	if len(testcase.Checks) == 0 {
+		// Without checks the testcase only has to run successfully.
+		result.Passed = result.Passed && run_result.exit_code == 0
+	}
+	for _, check := range testcase.Checks {
+		check_result := runCheck(workspace, check, run_result)
+		if !check_result.Passed && !check_result.Skipped {
+			result.Passed = false
+		}
+		result.Checks = append(result.Checks, check_result)
+	}
+	if result.Passed {
+		result.Score = testcase.Score
+	}
+	return result, nil
+}
+
+// Run builds the solution with the testbase in a temporary folder and runs every testcase against it.
+func Run(config Config) ([]TestcaseResult, error) {
+	testcases_path := config.TestcasesPath
+	if testcases_path == "" {
+		testcases_path = filepath.Join(config.FolderPath, TestcasesFile)
+	}
+	testcases, err := LoadTestcases(testcases_path)
+	if err != nil {
+		return nil, err
+	}
+
+	workspace, err := prepareWorkspace(config)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workspace)
+
+	if config.BuildCommand != "" {
+		build_result, err := run(workspace, config.BuildCommand, nil, config.RunLimit)
+		if err != nil {
+			return nil, err
+		}
+		if build_result.timed_out || build_result.output_exceeded || build_result.exit_code != 0 {
+			return nil, fmt.Errorf("Build failed with exit code %d:\n%s", build_result.exit_code, string(build_result.output))
+		}
+	}
+
+	results := make([]TestcaseResult, 0, len(testcases))
+	for _, testcase := range testcases {
+		result, err := runTestcase(workspace, config, testcase)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, *result)
+	}
+	return results, nil
+}
+
+// Report formats the results, one line per testcase and check, followed by the total score.
+func Report(results []TestcaseResult) string {
+	lines := make([]string, 0)
+	score := 0
+	max_score := 0
+	for _, result := range results {
+		status := "FAIL"
+		if result.Skipped {
+			status = "SKIP"
+		} else if result.Passed {
+			status = "PASS"
+		}
+		line := fmt.Sprintf("%s %s (%d/%d)", status, result.Name, result.Score, result.MaxScore)
+		if result.TimedOut {
+			line += " timed out"
+		} else if result.OutputExceeded {
+			line += " output limit exceeded"
+		} else if !result.Skipped && len(result.Checks) == 0 && result.ExitCode != 0 {
+			line += fmt.Sprintf(" exit code %d", result.ExitCode)
+		}
+		lines = append(lines, line)
+		for _, check := range result.Checks {
+			if check.Message != "" {
+				lines = append(lines, fmt.Sprintf("    %s %s: %s", check.Type, check.Name, check.Message))
+			}
+		}
+		score += result.Score
+		max_score += result.MaxScore
+	}
+	lines = append(lines, fmt.Sprintf("Score: %d/%d", score, max_score))
+	return strings.Join(lines, "\n")
+}
+
+// Passed reports whether every testcase that was run passed.
+func Passed(results []TestcaseResult) bool {
+	for _, result := range results {
+		if !result.Skipped && !result.Passed {
+			return false
+		}
+	}
+	return true
+}
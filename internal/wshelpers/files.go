@@ -0,0 +1,37 @@
+package wshelpers
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
+
+// IsText reports whether contents can be sent through the OT protocol, which only carries strings.
+// Anything else, such as images, datasets or jar files, can't be transferred over the workspace websocket.
+func IsText(contents []byte) bool {
+	return utf8.Valid(contents) && !bytes.ContainsRune(contents, 0)
+}
+
+// HasReplacementChar reports whether contents holds U+FFFD. That is valid text, but in a file read over
+// the workspace websocket it may also be where Ed replaced bytes of a binary file it couldn't send.
+func HasReplacementChar(contents []byte) bool {
+	return bytes.ContainsRune(contents, utf8.RuneError)
+}
+
+// readRepoFile reads a file through the OT protocol. Ed sends the buffer as a string, so a binary file
+// comes back with its invalid bytes replaced. That is enough to hash it, but not to save it.
+func readRepoFile(c *Session, relative_path string) ([]byte, error) {
+	return readFile(c, fmt.Sprintf("/home/%s", relative_path))
+}
+
+// writeRepoFile sets the contents of relative_path, creating it unless exists is set. Binary files are
+// refused rather than uploaded with their invalid bytes replaced, as only the experimental_rest backend can upload them.
+func writeRepoFile(c *Session, relative_path string, contents []byte, exists bool) error {
+	if !IsText(contents) {
+		return fmt.Errorf("Binary files can't be uploaded with the default websocket workspace backend. Upload it through Ed and add it to ignore_patterns or a .edignore file, or set workspace_backend to experimental_rest")
+	}
+	if exists {
+		return ReplaceFileContents(c, relative_path, string(contents))
+	}
+	return WriteFileContents(c, relative_path, string(contents))
+}
@@ -0,0 +1,236 @@
+package wshelpers
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// IgnoreFileName is the name of the files listing paths which are never uploaded to a workspace.
+const IgnoreFileName = ".edignore"
+
+type ignorePattern struct {
+	segments []string
+	negate   bool
+	dir_only bool
+	// anchored patterns match the whole path from the folder holding them, others match any basename.
+	anchored bool
+}
+
+// ignoreRuleSet is the contents of one ignore file, applying to the paths below base. The file at the
+// root of the challenge folder has an empty base.
+type ignoreRuleSet struct {
+	base     string
+	patterns []ignorePattern
+}
+
+// IgnoreRules decides which local paths are left out of the workspaces. .edignore files follow gitignore
+// exactly, each applying below the folder holding them, so paths in the one at the root of the challenge
+// folder start with the repo, as in /scaffold/build. Patterns given directly are instead matched inside
+// every repo, and like .git/info/exclude have the lowest precedence.
+type IgnoreRules struct {
+	patterns []ignorePattern
+	files    []ignoreRuleSet
+}
+
+func parseIgnorePattern(line string) (ignorePattern, bool) {
+	line = strings.TrimRight(line, " \r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false
+	}
+
+	var pattern ignorePattern
+	if strings.HasPrefix(line, "!") {
+		pattern.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		pattern.dir_only = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		pattern.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return ignorePattern{}, false
+	}
+	pattern.segments = strings.Split(line, "/")
+	return pattern, true
+}
+
+func parseIgnorePatterns(lines []string) []ignorePattern {
+	patterns := make([]ignorePattern, 0)
+	for _, line := range lines {
+		pattern, ok := parseIgnorePattern(line)
+		if ok {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+func readIgnoreFile(file_path string) ([]ignorePattern, error) {
+	f, err := os.Open(file_path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return parseIgnorePatterns(lines), nil
+}
+
+// LoadIgnoreRules reads every .edignore under challenge_folder_path, along with the given patterns.
+func LoadIgnoreRules(challenge_folder_path string, patterns []string) (*IgnoreRules, error) {
+	rules := &IgnoreRules{patterns: parseIgnorePatterns(patterns)}
+	if challenge_folder_path == "" {
+		return rules, nil
+	}
+	if _, err := os.Stat(challenge_folder_path); os.IsNotExist(err) {
+		return rules, nil
+	}
+
+	err := filepath.Walk(challenge_folder_path,
+		func(file_path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || info.Name() != IgnoreFileName {
+				return nil
+			}
+			rel_dir, err := filepath.Rel(challenge_folder_path, filepath.Dir(file_path))
+			if err != nil {
+				return err
+			}
+			file_patterns, err := readIgnoreFile(file_path)
+			if err != nil {
+				return err
+			}
+			base := ""
+			if rel_dir != "." {
+				base = filepath.ToSlash(rel_dir)
+			}
+			rules.files = append(rules.files, ignoreRuleSet{base: base, patterns: file_patterns})
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	// Deeper files are consulted last, so they override the files above them.
+	sort.SliceStable(rules.files, func(i, j int) bool {
+		return ignoreFileDepth(rules.files[i].base) < ignoreFileDepth(rules.files[j].base)
+	})
+	return rules, nil
+}
+
+func ignoreFileDepth(base string) int {
+	if base == "" {
+		return 0
+	}
+	return strings.Count(base, "/") + 1
+}
+
+func matchSegments(pattern []string, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	// A trailing /** matches everything inside a folder, but not the folder itself.
+	if len(pattern) == 1 && pattern[0] == "**" {
+		return len(segments) > 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	ok, err := path.Match(pattern[0], segments[0])
+	if err != nil || !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], segments[1:])
+}
+
+func (p ignorePattern) matches(rel_path string, is_dir bool) bool {
+	if p.dir_only && !is_dir {
+		return false
+	}
+	if p.anchored {
+		return matchSegments(p.segments, strings.Split(rel_path, "/"))
+	}
+	return matchSegments(p.segments, []string{path.Base(rel_path)})
+}
+
+// ignoredEntry checks a single path, without looking at the folders above it.
+func (r *IgnoreRules) ignoredEntry(repo_name string, rel_path string, is_dir bool) bool {
+	ignored := false
+	for _, pattern := range r.patterns {
+		if pattern.matches(rel_path, is_dir) {
+			ignored = !pattern.negate
+		}
+	}
+	full_path := path.Join(repo_name, rel_path)
+	for _, set := range r.files {
+		set_rel_path := full_path
+		if set.base != "" {
+			if !strings.HasPrefix(full_path, set.base+"/") {
+				continue
+			}
+			set_rel_path = strings.TrimPrefix(full_path, set.base+"/")
+		}
+		for _, pattern := range set.patterns {
+			if pattern.matches(set_rel_path, is_dir) {
+				ignored = !pattern.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// Ignored reports whether rel_path inside repo_name is left out. As with git, nothing inside an
+// ignored folder can be included again, and the ignore files themselves are never uploaded.
+func (r *IgnoreRules) Ignored(repo_name string, rel_path string, is_dir bool) bool {
+	if r == nil {
+		return false
+	}
+	if !is_dir && path.Base(rel_path) == IgnoreFileName {
+		return true
+	}
+	segments := strings.Split(rel_path, "/")
+	for i := 1; i < len(segments); i++ {
+		if r.ignoredEntry(repo_name, strings.Join(segments[:i], "/"), true) {
+			return true
+		}
+	}
+	return r.ignoredEntry(repo_name, rel_path, is_dir)
+}
+
+// Filter removes the ignored entries from the files of a repo.
+func (r *IgnoreRules) Filter(repo_name string, files map[string]RepoFile) map[string]RepoFile {
+	filtered := make(map[string]RepoFile)
+	for rel_path, file := range files {
+		if !r.Ignored(repo_name, rel_path, file.IsDir) {
+			filtered[rel_path] = file
+		}
+	}
+	return filtered
+}
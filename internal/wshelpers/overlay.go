@@ -0,0 +1,138 @@
+package wshelpers
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// LocalChallenge is the local source of a challenge's workspaces: its folder_path, with any shared
+// overlay folders layered beneath it. Every folder has scaffold, solution and testbase folders of its
+// own, and where several hold the same path the later one wins, so folder_path always has the last say.
+type LocalChallenge struct {
+	FolderPath string
+	// OverlayPaths are the shared folders, from the bottom layer up.
+	OverlayPaths []string
+	// Rules are the .edignore files of FolderPath and the ignore patterns. Paths they match are left
+	// out of every layer, and are left alone in Ed.
+	Rules *IgnoreRules
+	// TemplateVars, when not nil, are used to render the files ending in TemplateSuffix.
+	TemplateVars map[string]string
+
+	overlay_rules []*IgnoreRules
+}
+
+// LoadLocalChallenge reads the ignore rules of folder_path and of each overlay folder. The ignore
+// patterns apply to every layer, while each folder's .edignore files only apply to its own files.
+func LoadLocalChallenge(folder_path string, overlay_paths []string, ignore_patterns []string) (*LocalChallenge, error) {
+	rules, err := LoadIgnoreRules(folder_path, ignore_patterns)
+	if err != nil {
+		return nil, err
+	}
+	local := &LocalChallenge{
+		FolderPath:    folder_path,
+		OverlayPaths:  overlay_paths,
+		Rules:         rules,
+		overlay_rules: make([]*IgnoreRules, 0, len(overlay_paths)),
+	}
+	for _, overlay_path := range overlay_paths {
+		rules, err := LoadIgnoreRules(overlay_path, ignore_patterns)
+		if err != nil {
+			return nil, err
+		}
+		local.overlay_rules = append(local.overlay_rules, rules)
+	}
+	return local, nil
+}
+
+// CheckOverlays reports the first overlay folder which does not exist. Unlike folder_path, a missing
+// overlay is never treated as empty, as it is almost certainly a typo.
+func (l *LocalChallenge) CheckOverlays() error {
+	for _, overlay_path := range l.OverlayPaths {
+		info, err := os.Stat(overlay_path)
+		if err != nil {
+			return fmt.Errorf("Overlay folder %s: %s", overlay_path, err.Error())
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("Overlay %s is not a folder", overlay_path)
+		}
+	}
+	return nil
+}
+
+// overlayRepoFiles lays upper over lower. A file or symlink replaces a folder below it along with
+// everything inside it.
+func overlayRepoFiles(lower map[string]RepoFile, upper map[string]RepoFile) {
+	for rel_path, file := range upper {
+		if lower_file, ok := lower[rel_path]; ok && lower_file.IsDir && !file.IsDir {
+			for lower_path := range lower {
+				if strings.HasPrefix(lower_path, rel_path+"/") {
+					delete(lower, lower_path)
+				}
+			}
+		}
+		lower[rel_path] = file
+	}
+}
+
+// ReadRepo reads repo_name from every layer and merges them, leaving out the ignored paths. Templates
+// are rendered once the layers are merged, so a template in one layer can be replaced by a higher one.
+func (l *LocalChallenge) ReadRepo(repo_name string) (map[string]RepoFile, error) {
+	err := l.CheckOverlays()
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]RepoFile)
+	for i, overlay_path := range l.OverlayPaths {
+		overlay_files, err := ReadLocalRepoFiles(overlay_path, repo_name, l.overlay_rules[i])
+		if err != nil {
+			return nil, err
+		}
+		overlayRepoFiles(files, overlay_files)
+	}
+	own_files, err := ReadLocalRepoFiles(l.FolderPath, repo_name, l.Rules)
+	if err != nil {
+		return nil, err
+	}
+	overlayRepoFiles(files, own_files)
+	files = l.Rules.Filter(repo_name, files)
+	if l.TemplateVars != nil {
+		err = renderTemplates(repo_name, files, l.TemplateVars)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// HasRepo reports whether any layer has a repo_name folder.
+func (l *LocalChallenge) HasRepo(repo_name string) (bool, error) {
+	err := l.CheckOverlays()
+	if err != nil {
+		return false, err
+	}
+	for _, folder_path := range append(append([]string{}, l.OverlayPaths...), l.FolderPath) {
+		info, err := os.Stat(path.Join(folder_path, repo_name))
+		if err == nil && info.IsDir() {
+			return true, nil
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// WorkspaceHash hashes the merged scaffold, solution and testbase folders.
+func (l *LocalChallenge) WorkspaceHash() (string, error) {
+	repos := make(map[string]map[string]RepoFile)
+	for _, repo_name := range ChallengeRepos {
+		files, err := l.ReadRepo(repo_name)
+		if err != nil {
+			return "", err
+		}
+		repos[repo_name] = files
+	}
+	return HashRepoFiles(repos), nil
+}
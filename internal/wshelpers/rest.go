@@ -0,0 +1,186 @@
+package wshelpers
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path"
+	"strings"
+
+	"terraform-provider-edstem/internal/client"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+// restWorkspace moves each repo as a single tar.gz archive, so a sync is one request
+// rather than a conversation over the editor websocket. The challenges/{id}/workspace/{repo}/archive
+// endpoint is not confirmed to exist in Ed, so the backend is only used as experimental_rest.
+type restWorkspace struct {
+	client *client.Client
+}
+
+func (w restWorkspace) ReadRepo(challenge_id int, repo_name string) (map[string]RepoFile, error) {
+	body, err := w.client.HTTPRequest(fmt.Sprintf("challenges/%d/workspace/%s/archive", challenge_id, repo_name), "GET", bytes.Buffer{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return UnarchiveRepoFiles(body)
+}
+
+// SyncRepo replaces the whole repo with the local folder, unless nothing has changed since known_hashes.
+// Like the websocket backend, ignored paths which only exist in Ed are kept, unless the folder holding
+// them is removed.
+func (w restWorkspace) SyncRepo(ctx context.Context, challenge_id int, local *LocalChallenge, repo_name string, known_hashes map[string]string) (map[string]string, error) {
+	local_files, err := local.ReadRepo(repo_name)
+	if err != nil {
+		return nil, err
+	}
+
+	new_hashes := repoHashes(local_files)
+	if known_hashes != nil && sameHashes(known_hashes, new_hashes) {
+		return new_hashes, nil
+	}
+
+	remote_files, err := w.ReadRepo(challenge_id, repo_name)
+	if err != nil {
+		return nil, err
+	}
+	upload_files := make(map[string]RepoFile)
+	for rel_path, file := range local_files {
+		upload_files[rel_path] = file
+	}
+	// Parent folders sort before their contents, so a kept folder is seen before anything inside it.
+	for _, rel_path := range sortedKeys(remote_files) {
+		remote_file := remote_files[rel_path]
+		if !local.Rules.Ignored(repo_name, rel_path, remote_file.IsDir) {
+			continue
+		}
+		parent := path.Dir(rel_path)
+		if parent != "." && !upload_files[parent].IsDir {
+			continue
+		}
+		upload_files[rel_path] = remote_file
+	}
+
+	archive, err := ArchiveRepoFiles(upload_files)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.Buffer{}
+	writer := multipart.NewWriter(&buf)
+	part, err := writer.CreateFormFile("archive", fmt.Sprintf("%s.tar.gz", repo_name))
+	if err != nil {
+		return nil, err
+	}
+	_, err = part.Write(archive)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	tflog.Info(ctx, "Uploading workspace archive", map[string]interface{}{"challenge_id": challenge_id, "repo": repo_name})
+	boundary := writer.Boundary()
+	_, err = w.client.HTTPRequest(fmt.Sprintf("challenges/%d/workspace/%s/archive", challenge_id, repo_name), "PUT", buf, &boundary)
+	if err != nil {
+		return nil, err
+	}
+	return new_hashes, nil
+}
+
+// ArchiveRepoFiles packs a workspace into a tar.gz, keeping symlinks and executable bits. Entries are
+// sorted and carry no timestamps or owners, so the same files always produce the same archive.
+func ArchiveRepoFiles(files map[string]RepoFile) ([]byte, error) {
+	buf := bytes.Buffer{}
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	for _, rel_path := range sortedKeys(files) {
+		file := files[rel_path]
+		header := &tar.Header{
+			Name:   rel_path,
+			Format: tar.FormatPAX,
+		}
+		if file.IsDir {
+			header.Typeflag = tar.TypeDir
+			header.Name = rel_path + "/"
+			header.Mode = 0755
+		} else if file.IsSymlink() {
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = file.LinkTarget
+			header.Mode = 0777
+		} else {
+			header.Typeflag = tar.TypeReg
+			header.Size = int64(len(file.Contents))
+			header.Mode = int64(repoFileMode(file.Mode))
+		}
+		err := tw.WriteHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg {
+			_, err = tw.Write(file.Contents)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err := tw.Close()
+	if err != nil {
+		return nil, err
+	}
+	err = gz.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnarchiveRepoFiles reads a tar.gz produced by ArchiveRepoFiles or by Ed.
+func UnarchiveRepoFiles(archive io.Reader) (map[string]RepoFile, error) {
+	gz, err := gzip.NewReader(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	files := make(map[string]RepoFile)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		rel_path := strings.TrimPrefix(path.Clean("/"+header.Name), "/")
+		if rel_path == "" {
+			continue
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			files[rel_path] = RepoFile{IsDir: true, Mode: os.FileMode(header.Mode).Perm() | os.ModeDir}
+		case tar.TypeSymlink:
+			files[rel_path] = RepoFile{Mode: os.ModeSymlink | 0777, LinkTarget: header.Linkname}
+		case tar.TypeReg:
+			contents, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			files[rel_path] = RepoFile{Mode: os.FileMode(header.Mode).Perm(), Contents: contents}
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,286 @@
+package wshelpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultReplyTimeout is how long a Session waits for a reply before giving up on the connection.
+const DefaultReplyTimeout = 30 * time.Second
+
+type ErrorFrame struct {
+	Type string         `json:"type"`
+	Data ErrorFrameData `json:"data"`
+}
+
+type ErrorFrameData struct {
+	Message string `json:"message"`
+}
+
+// FrameError is an error frame sent by the workspace server.
+type FrameError struct {
+	Message string
+}
+
+func (e *FrameError) Error() string {
+	return fmt.Sprintf("Workspace error: %s", e.Message)
+}
+
+type sessionReply struct {
+	message_type string
+	content      []byte
+	err          error
+}
+
+type sessionWaiter struct {
+	description string
+	accept      func(message_type string, content []byte) bool
+	reply       chan sessionReply
+}
+
+// Session is a workspace websocket connection. A background goroutine reads every frame and hands
+// it to the caller waiting for that reply, so unrequested frames are not mistaken for replies, and
+// error frames are reported to the caller rather than skipped. Error frames don't say which message
+// caused them, so only one request is in flight at a time.
+type Session struct {
+	conn    *websocket.Conn
+	timeout time.Duration
+
+	request_lock sync.Mutex
+	write_lock   sync.Mutex
+
+	lock    sync.Mutex
+	waiters []*sessionWaiter
+	// err is an error frame nobody was waiting on, returned from the next Send or Request.
+	err    error
+	closed error
+	done   chan struct{}
+}
+
+func newSession(conn *websocket.Conn) *Session {
+	return &Session{
+		conn:    conn,
+		timeout: DefaultReplyTimeout,
+		done:    make(chan struct{}),
+	}
+}
+
+// start runs the reader. Waiters registered before this are guaranteed to see the first frames.
+func (s *Session) start() {
+	go s.read()
+}
+
+func (s *Session) read() {
+	for {
+		_, content, err := s.conn.ReadMessage()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+
+		m_resp := &Message{}
+		err = json.Unmarshal(content, &m_resp)
+		if err != nil {
+			s.fail(fmt.Errorf("Could not decode workspace message: %s", err.Error()))
+			return
+		}
+
+		if m_resp.Type == "error" {
+			s.dispatchError(content)
+			continue
+		}
+		s.dispatch(m_resp.Type, content)
+	}
+}
+
+func (s *Session) dispatch(message_type string, content []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, waiter := range s.waiters {
+		if !waiter.accept(message_type, content) {
+			continue
+		}
+		s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+		waiter.reply <- sessionReply{message_type: message_type, content: content}
+		s.resetDeadline()
+		return
+	}
+}
+
+// dispatchError fails the waiting request. Ed handles messages in order and only one request is in
+// flight, so the error was caused by it.
+func (s *Session) dispatchError(content []byte) {
+	frame := &ErrorFrame{}
+	err := json.Unmarshal(content, &frame)
+	message := frame.Data.Message
+	if err != nil || message == "" {
+		message = string(content)
+	}
+	frame_err := &FrameError{Message: message}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.waiters) == 0 {
+		if s.err == nil {
+			s.err = frame_err
+		}
+		return
+	}
+	waiter := s.waiters[0]
+	s.waiters = s.waiters[1:]
+	waiter.reply <- sessionReply{err: frame_err}
+	s.resetDeadline()
+}
+
+func (s *Session) fail(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed == nil {
+		s.closed = err
+		close(s.done)
+	}
+	for _, waiter := range s.waiters {
+		waiter.reply <- sessionReply{err: err}
+	}
+	s.waiters = nil
+}
+
+// resetDeadline only lets reads time out while a reply is expected. Called with the lock held.
+func (s *Session) resetDeadline() {
+	if len(s.waiters) == 0 {
+		s.conn.SetReadDeadline(time.Time{})
+	} else {
+		s.conn.SetReadDeadline(time.Now().Add(s.timeout))
+	}
+}
+
+// wait registers interest in the next reply of reply_type accepted by match, which may be nil.
+func (s *Session) wait(reply_type string, match func([]byte) bool) (*sessionWaiter, error) {
+	return s.waitAny(reply_type, func(message_type string, content []byte) bool {
+		return message_type == reply_type && (match == nil || match(content))
+	})
+}
+
+// waitAny registers interest in the next message accepted by accept.
+func (s *Session) waitAny(description string, accept func(message_type string, content []byte) bool) (*sessionWaiter, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed != nil {
+		return nil, s.closed
+	}
+	if s.err != nil {
+		err := s.err
+		s.err = nil
+		return nil, err
+	}
+	waiter := &sessionWaiter{description: description, accept: accept, reply: make(chan sessionReply, 1)}
+	s.waiters = append(s.waiters, waiter)
+	s.resetDeadline()
+	return waiter, nil
+}
+
+func (s *Session) await(waiter *sessionWaiter) ([]byte, error) {
+	_, content, err := s.awaitAny(waiter)
+	return content, err
+}
+
+func (s *Session) awaitAny(waiter *sessionWaiter) (string, []byte, error) {
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	select {
+	case reply := <-waiter.reply:
+		return reply.message_type, reply.content, reply.err
+	case <-timer.C:
+		s.lock.Lock()
+		for i, other := range s.waiters {
+			if other == waiter {
+				s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+				break
+			}
+		}
+		s.resetDeadline()
+		s.lock.Unlock()
+		return "", nil, fmt.Errorf("Timed out waiting for workspace %s", waiter.description)
+	}
+}
+
+// Send writes a message Ed does not reply to, followed by a listing of /home. Ed handles messages in
+// order, so once the listing arrives the message is done, and any error frame it caused has been
+// returned here rather than failing a later request.
+func (s *Session) Send(message any) error {
+	var barrier FSOPRequest
+	barrier.Type = "fsop"
+	barrier.Data.Type = "list_folder"
+	barrier.Data.Param1 = "/home"
+
+	s.request_lock.Lock()
+	defer s.request_lock.Unlock()
+	waiter, err := s.wait("list_reply", nil)
+	if err != nil {
+		return err
+	}
+	for _, m := range []any{message, barrier} {
+		err = s.write(m)
+		if err != nil {
+			s.fail(err)
+			return err
+		}
+	}
+	_, err = s.await(waiter)
+	return err
+}
+
+func (s *Session) write(message any) error {
+	req_body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	s.write_lock.Lock()
+	defer s.write_lock.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	return s.conn.WriteMessage(websocket.BinaryMessage, req_body)
+}
+
+// Request writes a message and waits for the reply of reply_type accepted by match, which may be nil.
+func (s *Session) Request(message any, reply_type string, match func([]byte) bool) ([]byte, error) {
+	s.request_lock.Lock()
+	defer s.request_lock.Unlock()
+	waiter, err := s.wait(reply_type, match)
+	if err != nil {
+		return nil, err
+	}
+	err = s.write(message)
+	if err != nil {
+		s.fail(err)
+		return nil, err
+	}
+	return s.await(waiter)
+}
+
+// RequestAny writes a message and waits for the first message accepted by accept, returning its type.
+func (s *Session) RequestAny(message any, description string, accept func(message_type string, content []byte) bool) (string, []byte, error) {
+	s.request_lock.Lock()
+	defer s.request_lock.Unlock()
+	waiter, err := s.waitAny(description, accept)
+	if err != nil {
+		return "", nil, err
+	}
+	err = s.write(message)
+	if err != nil {
+		s.fail(err)
+		return "", nil, err
+	}
+	return s.awaitAny(waiter)
+}
+
+// Close closes the connection and stops the reader.
+func (s *Session) Close() error {
+	err := s.conn.Close()
+	<-s.done
+	return err
+}
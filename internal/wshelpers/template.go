@@ -0,0 +1,50 @@
+package wshelpers
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// TemplateSuffix marks the workspace files rendered with the template variables of a challenge. The
+// rendered file is uploaded without the suffix, so main.py.tmpl becomes main.py.
+const TemplateSuffix = ".tmpl"
+
+// renderTemplates replaces every regular file ending in TemplateSuffix with its output when executed as
+// a text/template with vars. Using a variable which is not in vars is an error, so typos are not
+// silently uploaded as blank text.
+func renderTemplates(repo_name string, files map[string]RepoFile, vars map[string]string) error {
+	// Templates are found first, so a rendered a.tmpl from a.tmpl.tmpl isn't rendered again.
+	template_paths := make([]string, 0)
+	for rel_path, file := range files {
+		if !file.IsDir && file.LinkTarget == "" && strings.HasSuffix(rel_path, TemplateSuffix) {
+			template_paths = append(template_paths, rel_path)
+		}
+	}
+	sort.Strings(template_paths)
+
+	for _, rel_path := range template_paths {
+		file := files[rel_path]
+		rendered_path := strings.TrimSuffix(rel_path, TemplateSuffix)
+		if rendered_path == "" || strings.HasSuffix(rendered_path, "/") {
+			continue
+		}
+		if _, ok := files[rendered_path]; ok {
+			return fmt.Errorf("%s/%s would be replaced by the rendered %s", repo_name, rendered_path, rel_path)
+		}
+		tmpl, err := template.New(rel_path).Option("missingkey=error").Parse(string(file.Contents))
+		if err != nil {
+			return fmt.Errorf("%s: %s", repo_name, err.Error())
+		}
+		var buf bytes.Buffer
+		err = tmpl.Execute(&buf, vars)
+		if err != nil {
+			return fmt.Errorf("%s: %s", repo_name, err.Error())
+		}
+		delete(files, rel_path)
+		files[rendered_path] = RepoFile{Mode: file.Mode, Contents: buf.Bytes()}
+	}
+	return nil
+}
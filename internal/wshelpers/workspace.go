@@ -0,0 +1,260 @@
+package wshelpers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"terraform-provider-edstem/internal/client"
+)
+
+const (
+	// WorkspaceBackendExperimentalRest moves whole workspaces as archives through the REST API. The
+	// archive endpoints are not confirmed to exist in Ed.
+	WorkspaceBackendExperimentalRest = "experimental_rest"
+	// WorkspaceBackendWebsocket edits workspaces file by file over the editor websocket.
+	WorkspaceBackendWebsocket = "websocket"
+)
+
+// WorkspaceBackends are the values accepted for client.Client.WorkspaceBackend.
+var WorkspaceBackends = []string{WorkspaceBackendExperimentalRest, WorkspaceBackendWebsocket}
+
+// Workspace reads and writes the repos attached to a challenge.
+type Workspace interface {
+	// ReadRepo reads every file and directory of a repo into memory, keyed by path relative to the repo root.
+	ReadRepo(challenge_id int, repo_name string) (map[string]RepoFile, error)
+	// SyncRepo makes the repo match repo_name merged from the layers of local, except for paths matched
+	// by local.Rules. known_hashes holds the RepoFile.Hash of each file as last uploaded, as returned by
+	// repoHashes, and the hashes of the files after the sync are returned.
+	SyncRepo(ctx context.Context, challenge_id int, local *LocalChallenge, repo_name string, known_hashes map[string]string) (map[string]string, error)
+}
+
+// NewWorkspace returns the backend selected by conn.WorkspaceBackend, defaulting to the websocket. The
+// archive endpoints used by the REST backend are not yet confirmed to exist in Ed, so it must be chosen
+// explicitly as experimental_rest.
+func NewWorkspace(conn *client.Client) (Workspace, error) {
+	switch conn.WorkspaceBackend {
+	case "", WorkspaceBackendWebsocket:
+		return websocketWorkspace{client: conn}, nil
+	case WorkspaceBackendExperimentalRest:
+		return restWorkspace{client: conn}, nil
+	}
+	return nil, fmt.Errorf("Unknown workspace backend %q", conn.WorkspaceBackend)
+}
+
+// PathError records the repo, and the file within it if known, that a workspace operation failed on.
+type PathError struct {
+	Repo string
+	Path string
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s: %s", e.Repo, e.Err.Error())
+	}
+	return fmt.Sprintf("%s/%s: %s", e.Repo, e.Path, e.Err.Error())
+}
+
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+// SyncError collects the failures of every repo in a challenge, so one failing repo does not
+// hide the state of the others.
+type SyncError struct {
+	Errors []error
+}
+
+func (e *SyncError) Error() string {
+	lines := make([]string, 0, len(e.Errors)+1)
+	lines = append(lines, fmt.Sprintf("%d workspace(s) failed to sync:", len(e.Errors)))
+	for _, err := range e.Errors {
+		lines = append(lines, fmt.Sprintf("  %s", err.Error()))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// UpdateChallengeRepo syncs repo_name of local to the workspace. Errors are returned as a *PathError.
+func UpdateChallengeRepo(ctx context.Context, conn *client.Client, challenge_id int, local *LocalChallenge, repo_name string, known_hashes map[string]string) (map[string]string, error) {
+	workspace, err := NewWorkspace(conn)
+	if err != nil {
+		return nil, &PathError{Repo: repo_name, Err: err}
+	}
+	hashes, err := workspace.SyncRepo(ctx, challenge_id, local, repo_name, known_hashes)
+	if err != nil {
+		if _, ok := err.(*PathError); !ok {
+			err = &PathError{Repo: repo_name, Err: err}
+		}
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// ReadRepoFiles reads every file and directory of a challenge workspace into memory.
+func ReadRepoFiles(conn *client.Client, challenge_id int, repo_name string) (map[string]RepoFile, error) {
+	workspace, err := NewWorkspace(conn)
+	if err != nil {
+		return nil, err
+	}
+	return workspace.ReadRepo(challenge_id, repo_name)
+}
+
+// ReadChallengeRepo saves a challenge workspace to challenge_folder_path/repo_name. Binary files read over
+// the websocket have lost their original bytes, so they are skipped rather than saved corrupted, and text
+// which may have had bytes replaced is saved with a warning.
+func ReadChallengeRepo(conn *client.Client, challenge_id int, challenge_folder_path string, repo_name string) error {
+	workspace, err := NewWorkspace(conn)
+	if err != nil {
+		return &PathError{Repo: repo_name, Err: err}
+	}
+	files, err := workspace.ReadRepo(challenge_id, repo_name)
+	if err != nil {
+		return &PathError{Repo: repo_name, Err: err}
+	}
+	SkipBinaryFiles(conn, repo_name, files)
+
+	if len(files) == 0 {
+		return nil
+	}
+	return WriteLocalRepoFiles(challenge_folder_path, repo_name, files)
+}
+
+// SkipBinaryFiles removes the binary files from a repo read by ReadRepoFiles, as over the websocket they
+// have lost their original bytes, and returns their paths. Text which may have had bytes replaced is kept
+// with a warning. The experimental_rest backend reads files exactly, so nothing is removed.
+func SkipBinaryFiles(conn *client.Client, repo_name string, files map[string]RepoFile) []string {
+	skipped := make([]string, 0)
+	if conn.WorkspaceBackend == WorkspaceBackendExperimentalRest {
+		return skipped
+	}
+	for _, rel_path := range sortedKeys(files) {
+		file := files[rel_path]
+		if file.IsDir || file.IsSymlink() {
+			continue
+		}
+		if !IsText(file.Contents) {
+			fmt.Printf("Skipping binary file %s/%s, which can't be downloaded over the workspace websocket\n", repo_name, rel_path)
+			delete(files, rel_path)
+			skipped = append(skipped, rel_path)
+		} else if HasReplacementChar(file.Contents) {
+			fmt.Printf("Warning: %s/%s contains U+FFFD, so it may be a binary file which lost bytes over the workspace websocket\n", repo_name, rel_path)
+		}
+	}
+	return skipped
+}
+
+// WriteLocalRepoFiles writes files into challenge_folder_path/repo_name, over anything already there.
+// Symlinks must stay inside the repo, and are only created once every file is written, so nothing is
+// written through a link.
+func WriteLocalRepoFiles(challenge_folder_path string, repo_name string, files map[string]RepoFile) error {
+	root := path.Join(challenge_folder_path, repo_name)
+	err := os.MkdirAll(root, 0777)
+	if err != nil {
+		return &PathError{Repo: repo_name, Err: err}
+	}
+
+	links := make([]string, 0)
+	// Folders sort before their contents, so they exist before any file is written into them.
+	for _, rel_path := range sortedKeys(files) {
+		file := files[rel_path]
+		local_path := path.Join(root, rel_path)
+		if file.IsSymlink() {
+			err = checkLinkTarget(rel_path, file.LinkTarget)
+			if err != nil {
+				return &PathError{Repo: repo_name, Path: rel_path, Err: err}
+			}
+			links = append(links, rel_path)
+			continue
+		}
+		err = checkLocalParents(root, rel_path)
+		if err != nil {
+			return &PathError{Repo: repo_name, Path: rel_path, Err: err}
+		}
+		if file.IsDir {
+			// A symlink left from an earlier copy is replaced, rather than followed.
+			info, err := os.Lstat(local_path)
+			if err == nil && info.Mode()&os.ModeSymlink != 0 {
+				err = os.Remove(local_path)
+				if err != nil {
+					return &PathError{Repo: repo_name, Path: rel_path, Err: err}
+				}
+			}
+			err = os.MkdirAll(local_path, 0777)
+			if err != nil {
+				return &PathError{Repo: repo_name, Path: rel_path, Err: err}
+			}
+			continue
+		}
+		err = writeLocalFile(local_path, file)
+		if err != nil {
+			return &PathError{Repo: repo_name, Path: rel_path, Err: err}
+		}
+	}
+
+	for _, rel_path := range links {
+		local_path := path.Join(root, rel_path)
+		err = checkLocalParents(root, rel_path)
+		if err != nil {
+			return &PathError{Repo: repo_name, Path: rel_path, Err: err}
+		}
+		err = os.Remove(local_path)
+		if err != nil && !os.IsNotExist(err) {
+			return &PathError{Repo: repo_name, Path: rel_path, Err: err}
+		}
+		err = os.Symlink(filepath.FromSlash(files[rel_path].LinkTarget), local_path)
+		if err != nil {
+			return &PathError{Repo: repo_name, Path: rel_path, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// checkLinkTarget rejects symlinks which point outside the repo, as they could be used to write
+// anywhere on disk.
+func checkLinkTarget(rel_path string, target string) error {
+	if target == "" || path.IsAbs(target) || filepath.IsAbs(filepath.FromSlash(target)) {
+		return fmt.Errorf("Symlink target %q is not a path inside the workspace", target)
+	}
+	resolved := path.Join(path.Dir(rel_path), target)
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return fmt.Errorf("Symlink target %q points outside the workspace", target)
+	}
+	return nil
+}
+
+// checkLocalParents rejects paths below a symlink, which would be written wherever the link points.
+func checkLocalParents(root string, rel_path string) error {
+	parent := path.Dir(rel_path)
+	for parent != "." {
+		info, err := os.Lstat(path.Join(root, parent))
+		if err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s is a symlink", parent)
+		}
+		parent = path.Dir(parent)
+	}
+	return nil
+}
+
+// writeLocalFile replaces whatever is at local_path with file. The old entry is removed and the file is
+// created exclusively, so a symlink at local_path is never followed.
+func writeLocalFile(local_path string, file RepoFile) error {
+	err := os.Remove(local_path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(local_path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, repoFileMode(file.Mode))
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(file.Contents)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
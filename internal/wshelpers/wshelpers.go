@@ -2,11 +2,15 @@ package wshelpers
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/gorilla/websocket"
@@ -47,6 +51,28 @@ type ListingData struct {
 type ListingEntry struct {
 	Name string `json:"name"`
 	Type string `json:"type"`
+	// Mode holds the permission bits, when the server reports them.
+	Mode   int    `json:"mode,omitempty"`
+	Target string `json:"target,omitempty"`
+}
+
+// IsDir reports whether the entry is a folder. Files and symlinks are the only other types.
+func (entry ListingEntry) IsDir() bool {
+	return entry.Type != "file" && entry.Type != "symlink"
+}
+
+// repoFile describes the entry as a RepoFile, without its contents.
+func (entry ListingEntry) repoFile() RepoFile {
+	if entry.IsDir() {
+		return RepoFile{IsDir: true}
+	}
+	if entry.Type == "symlink" {
+		return RepoFile{Mode: os.ModeSymlink | 0777, LinkTarget: entry.Target}
+	}
+	if entry.Mode == 0 {
+		return RepoFile{ModeUnknown: true}
+	}
+	return RepoFile{Mode: os.FileMode(entry.Mode).Perm()}
 }
 
 type FileOpenCommand struct {
@@ -102,57 +128,40 @@ type FileOTWriteCommand struct {
 	Data FileOTWriteData `json:"data"`
 }
 
-func DeleteAllFiles(conn *websocket.Conn) error {
-	var req FSOPRequest
-	req.Type = "fsop"
-	req.Data.Type = "list_folder"
-	req.Data.Param1 = "/home"
+// FileOTAck acknowledges a file_ot op, with the revision the file is at once it is applied.
+type FileOTAck struct {
+	Type string        `json:"type"`
+	Data FileOTAckData `json:"data"`
+}
 
-	req_body, err := json.Marshal(req)
-	if err != nil {
-		return err
-	}
+type FileOTAckData struct {
+	FID      int `json:"fid"`
+	Revision int `json:"rev"`
+}
 
-	err = conn.WriteMessage(websocket.BinaryMessage, req_body)
-	if err != nil {
-		fmt.Printf("Write error\n")
-		return err
-	}
+// maxOTAttempts bounds how often a write is retried after another editor changed the file during it.
+const maxOTAttempts = 5
 
-	content, get_err := GetMessage(conn, "list_reply")
-	if get_err != nil {
-		return get_err
-	}
+// errConcurrentEdit means another editor's op on the file landed before the write was acknowledged.
+var errConcurrentEdit = fmt.Errorf("edited concurrently")
 
-	m_resp := &ListingReply{}
-	err = json.Unmarshal(content, &m_resp)
+func DeleteAllFiles(conn *Session) error {
+	listing, err := listFolder(conn, "/home")
 	if err != nil {
 		return err
 	}
 
-	for _, returned := range m_resp.Data.Listing {
-		var req FSOPRequest
-		req.Type = "fsop"
-		req.Data.Type = "remove"
-		req.Data.Param1 = fmt.Sprintf("/home/%s", returned.Name)
-		req.Data.Param3 = returned.Type
-
-		req_body, err := json.Marshal(req)
+	for _, returned := range listing {
+		err = RemovePath(conn, returned.Name, returned.Type)
 		if err != nil {
 			return err
 		}
-
-		err = conn.WriteMessage(websocket.BinaryMessage, req_body)
-		if err != nil {
-			fmt.Printf("Write error\n")
-			return err
-		}
 	}
 
 	return nil
 }
 
-func CreateDir(conn *websocket.Conn, relative_path string) error {
+func CreateDir(conn *Session, relative_path string) error {
 	var req FSOPRequest
 	req.Type = "fsop"
 	req.Data.Type = "new_folder"
@@ -160,26 +169,20 @@ func CreateDir(conn *websocket.Conn, relative_path string) error {
 	req.Data.Param2 = ""
 	req.Data.Param3 = ""
 
-	req_body, err := json.Marshal(req)
-	if err != nil {
-		return err
-	}
+	return conn.Send(req)
+}
 
-	err = conn.WriteMessage(websocket.BinaryMessage, req_body)
+// WriteFileContents creates a new file holding file_contents.
+func WriteFileContents(conn *Session, relative_path string, file_contents string) error {
+	err := newFile(conn, relative_path)
 	if err != nil {
-		fmt.Printf("Write error\n")
 		return err
 	}
 
-	return nil
+	return ReplaceFileContents(conn, relative_path, file_contents)
 }
 
-func WriteFileContents(conn *websocket.Conn, relative_path string, file_contents string) error {
-	// This needs to:
-	// 1: Create the file
-	// 2: Get the FID
-	// 3: Set the cursor position
-	// 4: Insert the text
+func newFile(conn *Session, relative_path string) error {
 	var req FSOPRequest
 	req.Type = "fsop"
 	req.Data.Type = "new_file"
@@ -187,312 +190,581 @@ func WriteFileContents(conn *websocket.Conn, relative_path string, file_contents
 	req.Data.Param2 = ""
 	req.Data.Param3 = ""
 
-	req_body, err := json.Marshal(req)
-	if err != nil {
-		return err
-	}
-
-	err = conn.WriteMessage(websocket.BinaryMessage, req_body)
-	if err != nil {
-		fmt.Printf("Write error\n")
-		return err
-	}
+	return conn.Send(req)
+}
 
+func openFile(conn *Session, web_path string) (*FileOTInitData, error) {
 	var new_req FileOpenCommand
 	new_req.Type = "file_open"
-	new_req.Data.Path = strings.ReplaceAll(filepath.Join("/home", relative_path), "\\", "/")
+	new_req.Data.Path = web_path
 	new_req.Data.Soft = true
 
-	req_body, err = json.Marshal(new_req)
+	content, err := conn.Request(new_req, "file_ot_init", nil)
 	if err != nil {
-		return err
-	}
-
-	err = conn.WriteMessage(websocket.BinaryMessage, req_body)
-	if err != nil {
-		fmt.Printf("Write error\n")
-		return err
-	}
-
-	content, get_err := GetMessage(conn, "file_ot_init")
-	if get_err != nil {
-		return get_err
+		return nil, err
 	}
 
 	ot_resp := &FileOTInitRespose{}
 	err = json.Unmarshal(content, &ot_resp)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return &ot_resp.Data, nil
+}
 
-	var ot_cursor FileOTCommandCursor
-	ot_cursor.Type = "file_ot"
-	ot_cursor.Data.FID = ot_resp.Data.FID
-	ot_cursor.Data.Revision = ot_resp.Data.Revision
-	ot_cursor.Data.CursorData.Start = 0
-	ot_cursor.Data.CursorData.End = 0
+// ReplaceFileContents sets the contents of an existing file in place through OT. The ops are sent against
+// the revision the file was opened at, and the write is done once Ed acknowledges them and the file reads
+// back as file_contents at the acknowledged revision. If another editor's op lands first, the write is
+// retried on top of it.
+func ReplaceFileContents(conn *Session, relative_path string, file_contents string) error {
+	web_path := strings.ReplaceAll(filepath.Join("/home", relative_path), "\\", "/")
 
-	req_body, err = json.Marshal(ot_cursor)
-	if err != nil {
-		return err
+	for attempt := 0; ; attempt++ {
+		file, err := openFile(conn, web_path)
+		if err != nil {
+			return err
+		}
+		if file.Buffer == file_contents {
+			return nil
+		}
+		if attempt == maxOTAttempts {
+			return fmt.Errorf("Could not write %s after %d attempts, it kept changing during the write", relative_path, maxOTAttempts)
+		}
+		if attempt > 0 {
+			fmt.Printf("Retrying %s: edited concurrently\n", relative_path)
+		}
+
+		revision, err := writeFileOT(conn, file, file_contents)
+		if err == errConcurrentEdit {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		stored, err := openFile(conn, web_path)
+		if err != nil {
+			return err
+		}
+		if stored.Revision != revision {
+			// Another editor changed the file after the acknowledgement, so write it again.
+			continue
+		}
+		if stored.Buffer != file_contents {
+			return fmt.Errorf("Ed acknowledged the write of %s at revision %d, but stored different contents, which may have been normalised", relative_path, revision)
+		}
+		return nil
 	}
+}
 
-	err = conn.WriteMessage(websocket.BinaryMessage, req_body)
+// writeFileOT replaces the buffer of file with file_contents and returns the revision Ed acknowledged.
+// errConcurrentEdit is returned if an op from another editor arrives first.
+func writeFileOT(conn *Session, file *FileOTInitData, file_contents string) (int, error) {
+	var ot_cursor FileOTCommandCursor
+	ot_cursor.Type = "file_ot"
+	ot_cursor.Data.FID = file.FID
+	ot_cursor.Data.Revision = file.Revision
+	err := conn.Send(ot_cursor)
 	if err != nil {
-		fmt.Printf("Write error\n")
-		return err
+		return 0, err
 	}
 
 	var ot_write FileOTWriteCommand
 	ot_write.Type = "file_ot"
-	ot_write.Data.FID = ot_resp.Data.FID
-	ot_write.Data.Revision = ot_resp.Data.Revision
-	var ot_write_1 FileOTWriteOp
-	ot_write_1.Type = "insert"
-	ot_write_1.Value = file_contents
-	ot_write.Data.Operations = append(ot_write.Data.Operations, ot_write_1)
-
-	req_body, err = json.Marshal(ot_write)
-	if err != nil {
-		return err
+	ot_write.Data.FID = file.FID
+	ot_write.Data.Revision = file.Revision
+	if file.Buffer != "" {
+		ot_write.Data.Operations = append(ot_write.Data.Operations, FileOTWriteOp{Type: "delete", Value: file.Buffer})
+	}
+	if file_contents != "" {
+		ot_write.Data.Operations = append(ot_write.Data.Operations, FileOTWriteOp{Type: "insert", Value: file_contents})
 	}
 
-	err = conn.WriteMessage(websocket.BinaryMessage, req_body)
+	// Either the acknowledgement arrives, or an op from another editor on the same file means ours
+	// was based on a stale revision.
+	message_type, content, err := conn.RequestAny(ot_write, fmt.Sprintf("acknowledgement of file %d", file.FID), func(message_type string, content []byte) bool {
+		if message_type != "file_ot_ack" && message_type != "file_ot" {
+			return false
+		}
+		ack := &FileOTAck{}
+		if json.Unmarshal(content, &ack) != nil {
+			return false
+		}
+		return ack.Data.FID == file.FID
+	})
 	if err != nil {
-		fmt.Printf("Write error\n")
-		return err
+		return 0, err
 	}
+	if message_type == "file_ot" {
+		return 0, errConcurrentEdit
+	}
+	ack := &FileOTAck{}
+	err = json.Unmarshal(content, &ack)
+	if err != nil {
+		return 0, err
+	}
+	if ack.Data.Revision <= file.Revision {
+		return 0, fmt.Errorf("Ed acknowledged file %d at revision %d, which is not after revision %d", file.FID, ack.Data.Revision, file.Revision)
+	}
+	return ack.Data.Revision, nil
+}
 
-	return nil
+func RemovePath(conn *Session, relative_path string, entry_type string) error {
+	var req FSOPRequest
+	req.Type = "fsop"
+	req.Data.Type = "remove"
+	req.Data.Param1 = strings.ReplaceAll(filepath.Join("/home", relative_path), "\\", "/")
+	req.Data.Param3 = entry_type
+
+	return conn.Send(req)
 }
 
-func GetMessage(conn *websocket.Conn, message_type string) ([]byte, error) {
-	cur_type := ""
-	var mcontent []byte
-	var err error
+// CreateSymlink and SetMode use fsops which are not part of the protocol seen from the Ed editor, so
+// they are only sent when client.Client.ExperimentalWorkspaceFSOps is set.
+func CreateSymlink(conn *Session, relative_path string, target string) error {
+	var req FSOPRequest
+	req.Type = "fsop"
+	req.Data.Type = "new_symlink"
+	req.Data.Param1 = strings.ReplaceAll(filepath.Join("/home", relative_path), "\\", "/")
+	req.Data.Param2 = target
 
-	for cur_type != message_type {
-		_, mcontent, err = conn.ReadMessage()
-		if err != nil {
-			fmt.Printf("Read\n error")
-			return nil, err
-		}
-		m_resp := &Message{}
-		err = json.Unmarshal(mcontent, &m_resp)
-		if err != nil {
-			return nil, err
-		}
+	return conn.Send(req)
+}
 
-		cur_type = m_resp.Type
+// SetMode sets the permission bits of a file, so scripts in the testbase stay executable.
+func SetMode(conn *Session, relative_path string, mode os.FileMode) error {
+	var req FSOPRequest
+	req.Type = "fsop"
+	req.Data.Type = "chmod"
+	req.Data.Param1 = strings.ReplaceAll(filepath.Join("/home", relative_path), "\\", "/")
+	req.Data.Param2 = fmt.Sprintf("%o", repoFileMode(mode))
+
+	return conn.Send(req)
+}
+
+// repoFileMode is the mode a file is stored with in a workspace. Only the executable bit is
+// carried over, the rest depends on the local umask.
+func repoFileMode(mode os.FileMode) os.FileMode {
+	if mode.Perm()&0111 != 0 {
+		return 0755
 	}
+	return 0644
+}
 
-	return mcontent, nil
+// FileSha is the hex sha1 of the contents of a file.
+func FileSha(contents []byte) string {
+	sum := sha1.Sum(contents)
+	return hex.EncodeToString(sum[:])
 }
 
-func UpdateChallengeRepo(conn *client.Client, challenge_id int, challenge_folder_path string, repo_name string) error {
-	body, err := conn.HTTPRequest(fmt.Sprintf("challenges/%d/connect/%s", challenge_id, repo_name), "POST", bytes.Buffer{}, nil)
-	if err != nil {
-		return err
+func sortedKeys[V any](entries map[string]V) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
 	}
-	resp := &TicketResponse{}
-	err = json.NewDecoder(body).Decode(resp)
+	sort.Strings(keys)
+	return keys
+}
+
+// websocketWorkspace edits workspaces through the same connection as the Ed web editor.
+type websocketWorkspace struct {
+	client *client.Client
+}
+
+// SyncRepo only touches the files and folders that differ. Files without a known hash are
+// compared against their remote contents. Ignored paths are left alone in Ed. Symlinks and
+// executable bits are only synced with ExperimentalWorkspaceFSOps set, otherwise a symlink to a
+// file is uploaded as a copy of that file.
+func (w websocketWorkspace) SyncRepo(_ context.Context, challenge_id int, local *LocalChallenge, repo_name string, known_hashes map[string]string) (map[string]string, error) {
+	conn := w.client
+	local_files, err := local.ReadRepo(repo_name)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if !conn.ExperimentalWorkspaceFSOps {
+		for rel_path, file := range local_files {
+			if !file.IsSymlink() {
+				continue
+			}
+			if file.Resolved == nil {
+				return nil, &PathError{Repo: repo_name, Path: rel_path, Err: fmt.Errorf("Symlinks to folders or missing files are only uploaded with experimental_workspace_fsops enabled")}
+			}
+			fmt.Printf("Warning: uploading %s as a copy of %s, as symlinks are only uploaded with experimental_workspace_fsops enabled\n", rel_path, file.LinkTarget)
+			local_files[rel_path] = *file.Resolved
+		}
 	}
 
-	c, _, ws_err := websocket.DefaultDialer.Dial(fmt.Sprintf("wss://sahara.au.edstem.org/connect?ticket=%s", resp.Ticket), nil)
-	if ws_err != nil {
-		return ws_err
+	c, err := connectRepo(conn, challenge_id, repo_name)
+	if err != nil {
+		return nil, err
 	}
 	defer c.Close()
 
-	cur_type := "init"
+	remote_entries := make(map[string]ListingEntry)
+	err = listRemoteTree(c, "/home", "", remote_entries)
+	if err != nil {
+		return nil, err
+	}
 
-	for cur_type != "client_join" {
-		_, mcontent, merr := c.ReadMessage()
-		if merr != nil {
-			fmt.Printf("Read\n error")
-			return merr
+	// Remove anything that no longer exists locally, or has switched between file, folder and symlink.
+	// Ignored paths were never uploaded, so anything at them in Ed was added there and is kept.
+	for _, rel_path := range sortedKeys(remote_entries) {
+		remote, ok := remote_entries[rel_path]
+		if !ok {
+			// Already removed along with its parent folder.
+			continue
+		}
+		remote_file := remote.repoFile()
+		if local.Rules.Ignored(repo_name, rel_path, remote_file.IsDir) {
+			continue
 		}
-		m_resp := &Message{}
-		err = json.Unmarshal(mcontent, &m_resp)
+		local, ok := local_files[rel_path]
+		if ok && local.IsDir == remote_file.IsDir && local.IsSymlink() == remote_file.IsSymlink() {
+			continue
+		}
+		fmt.Printf("Removing %s\n", rel_path)
+		err = RemovePath(c, rel_path, remote.Type)
 		if err != nil {
-			return err
+			return nil, &PathError{Repo: repo_name, Path: rel_path, Err: err}
+		}
+		for other_path := range remote_entries {
+			if other_path == rel_path || strings.HasPrefix(other_path, rel_path+"/") {
+				delete(remote_entries, other_path)
+			}
 		}
-
-		cur_type = m_resp.Type
 	}
 
-	DeleteAllFiles(c)
-
-	err = filepath.Walk(filepath.Join(challenge_folder_path, repo_name),
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			var rel_path string
-			rel_path, err = filepath.Rel(filepath.Join(challenge_folder_path, repo_name), path)
-			if err != nil {
-				return err
-			}
-			if rel_path == "." {
-				return nil
-			}
-			if info.IsDir() {
+	// Parent folders sort before their contents, so they are always created first.
+	new_hashes := make(map[string]string)
+	for _, rel_path := range sortedKeys(local_files) {
+		local := local_files[rel_path]
+		_, exists := remote_entries[rel_path]
+		if local.IsDir {
+			new_hashes[rel_path+"/"] = ""
+			if !exists {
 				fmt.Printf("Making Dir %s\n", rel_path)
 				err = CreateDir(c, rel_path)
 				if err != nil {
-					return err
+					return nil, &PathError{Repo: repo_name, Path: rel_path, Err: err}
 				}
-			} else {
-				fmt.Printf("Writing File %s\n", rel_path)
-				dat, read_err := os.ReadFile(path)
-				if read_err != nil {
-					return read_err
+			}
+			continue
+		}
+
+		local_hash := local.Hash()
+		new_hashes[rel_path] = local_hash
+		if exists {
+			remote_hash, ok := known_hashes[rel_path]
+			if !ok {
+				remote_file := remote_entries[rel_path].repoFile()
+				if !remote_file.IsSymlink() {
+					remote_file.Contents, err = readRepoFile(c, rel_path)
+					if err != nil {
+						return nil, &PathError{Repo: repo_name, Path: rel_path, Err: err}
+					}
 				}
-				fmt.Printf("File contents %s\n", string(dat))
-				read_err = WriteFileContents(c, rel_path, string(dat))
-				if read_err != nil {
-					return read_err
+				remote_hash = remote_file.Hash()
+				// Without a remote mode the executable bit can't be compared either.
+				local.ModeUnknown = remote_file.ModeUnknown
+			}
+			if remote_hash == local.Hash() {
+				continue
+			}
+		}
+
+		if local.IsSymlink() {
+			if exists {
+				// A symlink can't be retargeted in place.
+				err = RemovePath(c, rel_path, "symlink")
+				if err != nil {
+					return nil, &PathError{Repo: repo_name, Path: rel_path, Err: err}
 				}
 			}
-			return nil
-		})
-	return err
+			fmt.Printf("Linking %s -> %s\n", rel_path, local.LinkTarget)
+			err = CreateSymlink(c, rel_path, local.LinkTarget)
+			if err != nil {
+				return nil, &PathError{Repo: repo_name, Path: rel_path, Err: err}
+			}
+			continue
+		}
+		fmt.Printf("Writing File %s\n", rel_path)
+		err = writeRepoFile(c, rel_path, local.Contents, exists)
+		if err != nil {
+			return nil, &PathError{Repo: repo_name, Path: rel_path, Err: err}
+		}
+		if conn.ExperimentalWorkspaceFSOps {
+			err = SetMode(c, rel_path, local.Mode)
+			if err != nil {
+				return nil, &PathError{Repo: repo_name, Path: rel_path, Err: err}
+			}
+		} else if local.IsExecutable() {
+			fmt.Printf("Warning: %s is executable, but executable bits are only set with experimental_workspace_fsops enabled\n", rel_path)
+		}
+	}
+
+	return new_hashes, nil
+}
+
+// ChallengeRepos are the workspaces attached to every challenge.
+var ChallengeRepos = []string{"scaffold", "solution", "testbase"}
+
+// RepoFile is a single entry of a workspace, keyed elsewhere by its path relative to the repo root.
+type RepoFile struct {
+	IsDir    bool
+	Mode     os.FileMode
+	Contents []byte
+	// LinkTarget is set for symlinks, whose Mode includes os.ModeSymlink.
+	LinkTarget string
+	// Resolved is the regular file a local symlink points to, uploaded in its place when symlinks can't be.
+	Resolved *RepoFile
+	// ModeUnknown is set for remote files listed without their mode, which is then left out of Hash.
+	ModeUnknown bool
+}
+
+func (file RepoFile) IsSymlink() bool {
+	return file.Mode&os.ModeSymlink != 0
+}
+
+func (file RepoFile) IsExecutable() bool {
+	return file.Mode.Perm()&0111 != 0
+}
+
+// Hash identifies the contents of a file or the target of a symlink, along with the executable
+// bit. Only the executable bit of the mode is included, as the rest depends on the local umask.
+func (file RepoFile) Hash() string {
+	if file.IsSymlink() {
+		return fmt.Sprintf("->%s", file.LinkTarget)
+	}
+	if file.IsExecutable() && !file.ModeUnknown {
+		return FileSha(file.Contents) + "+x"
+	}
+	return FileSha(file.Contents)
 }
 
-func ReadChallengeRepo(conn *client.Client, challenge_id int, challenge_folder_path string, repo_name string) error {
+// repoHashes maps each file of a repo to its RepoFile.Hash. Folders are included with a trailing slash and
+// no hash, so adding or removing an empty folder also counts as a change.
+func repoHashes(files map[string]RepoFile) map[string]string {
+	hashes := make(map[string]string)
+	for rel_path, file := range files {
+		if file.IsDir {
+			hashes[rel_path+"/"] = ""
+		} else {
+			hashes[rel_path] = file.Hash()
+		}
+	}
+	return hashes
+}
+
+func sameHashes(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		other, ok := b[key]
+		if !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+func connectRepo(conn *client.Client, challenge_id int, repo_name string) (*Session, error) {
 	body, err := conn.HTTPRequest(fmt.Sprintf("challenges/%d/connect/%s", challenge_id, repo_name), "POST", bytes.Buffer{}, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	resp := &TicketResponse{}
 	err = json.NewDecoder(body).Decode(resp)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	c, _, ws_err := websocket.DefaultDialer.Dial(fmt.Sprintf("wss://sahara.au.edstem.org/connect?ticket=%s", resp.Ticket), nil)
-	if ws_err != nil {
-		return ws_err
+	c, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("wss://sahara.au.edstem.org/connect?ticket=%s", resp.Ticket), nil)
+	if err != nil {
+		return nil, err
 	}
-	defer c.Close()
 
-	GetMessage(c, "client_join")
+	session := newSession(c)
+	join, err := session.wait("client_join", nil)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	session.start()
+	_, err = session.await(join)
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	return session, nil
+}
 
+func listFolder(conn *Session, web_path string) ([]ListingEntry, error) {
 	var req FSOPRequest
 	req.Type = "fsop"
 	req.Data.Type = "list_folder"
-	req.Data.Param1 = "/home"
+	req.Data.Param1 = web_path
 
-	req_body, err := json.Marshal(req)
+	// Listings of different folders may be in flight at once, so only accept the one for web_path.
+	content, err := conn.Request(req, "list_reply", func(content []byte) bool {
+		reply := &ListingReply{}
+		if json.Unmarshal(content, &reply) != nil {
+			return false
+		}
+		return reply.Data.Dir == "" || path.Clean(reply.Data.Dir) == path.Clean(web_path)
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = c.WriteMessage(websocket.BinaryMessage, req_body)
+	m_resp := &ListingReply{}
+	err = json.Unmarshal(content, &m_resp)
 	if err != nil {
-		fmt.Printf("Write error\n")
-		return err
+		return nil, err
 	}
+	return m_resp.Data.Listing, nil
+}
 
-	content, get_err := GetMessage(c, "list_reply")
-	if get_err != nil {
-		return get_err
+func readFile(conn *Session, web_path string) ([]byte, error) {
+	file, err := openFile(conn, web_path)
+	if err != nil {
+		return nil, err
 	}
+	return []byte(file.Buffer), nil
+}
 
-	m_resp := &ListingReply{}
-	err = json.Unmarshal(content, &m_resp)
+func listRemoteTree(conn *Session, web_path string, rel_path string, entries map[string]ListingEntry) error {
+	listing, err := listFolder(conn, web_path)
 	if err != nil {
 		return err
 	}
-
-	if len(m_resp.Data.Listing) != 0 {
-		os.MkdirAll(path.Join(challenge_folder_path, repo_name), 0777)
-	}
-
-	for _, returned := range m_resp.Data.Listing {
-		err = RecReadPath(c, fmt.Sprintf("/home/%s", returned.Name), path.Join(challenge_folder_path, repo_name, returned.Name), returned.Type != "file")
-		if err != nil {
-			return err
+	for _, entry := range listing {
+		entry_rel_path := path.Join(rel_path, entry.Name)
+		entries[entry_rel_path] = entry
+		if entry.IsDir() {
+			err = listRemoteTree(conn, fmt.Sprintf("%s/%s", web_path, entry.Name), entry_rel_path, entries)
+			if err != nil {
+				return err
+			}
 		}
 	}
-
 	return nil
 }
 
-func RecReadPath(conn *websocket.Conn, web_path string, local_path string, is_dir bool) error {
-	if !is_dir {
-		f, e := os.Create(local_path)
-		if e != nil {
-			return e
-		}
-		var new_req FileOpenCommand
-		new_req.Type = "file_open"
-		new_req.Data.Path = web_path
-		new_req.Data.Soft = true
-
-		req_body, err := json.Marshal(new_req)
-		if err != nil {
-			return err
-		}
-
-		err = conn.WriteMessage(websocket.BinaryMessage, req_body)
-		if err != nil {
-			fmt.Printf("Write error\n")
-			return err
-		}
-
-		content, get_err := GetMessage(conn, "file_ot_init")
-		if get_err != nil {
-			return get_err
-		}
-
-		ot_resp := &FileOTInitRespose{}
-		err = json.Unmarshal(content, &ot_resp)
-		if err != nil {
-			return err
-		}
-		f.WriteString(ot_resp.Data.Buffer)
-	} else {
-		err := os.MkdirAll(local_path, 0777)
-		if err != nil {
-			return err
-		}
-		var req FSOPRequest
-		req.Type = "fsop"
-		req.Data.Type = "list_folder"
-		req.Data.Param1 = web_path
-
-		req_body, err := json.Marshal(req)
-		if err != nil {
-			return err
-		}
-
-		err = conn.WriteMessage(websocket.BinaryMessage, req_body)
-		if err != nil {
-			fmt.Printf("Write error\n")
-			return err
-		}
+func (w websocketWorkspace) ReadRepo(challenge_id int, repo_name string) (map[string]RepoFile, error) {
+	conn := w.client
+	c, err := connectRepo(conn, challenge_id, repo_name)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
 
-		content, get_err := GetMessage(conn, "list_reply")
-		if get_err != nil {
-			return get_err
-		}
+	entries := make(map[string]ListingEntry)
+	err = listRemoteTree(c, "/home", "", entries)
+	if err != nil {
+		return nil, err
+	}
 
-		m_resp := &ListingReply{}
-		err = json.Unmarshal(content, &m_resp)
-		if err != nil {
-			return err
+	files := make(map[string]RepoFile)
+	for rel_path, entry := range entries {
+		file := entry.repoFile()
+		if !file.IsDir && !file.IsSymlink() {
+			file.Contents, err = readRepoFile(c, rel_path)
+			if err != nil {
+				return nil, err
+			}
 		}
+		files[rel_path] = file
+	}
+	return files, nil
+}
 
-		for _, returned := range m_resp.Data.Listing {
-			err = RecReadPath(conn, fmt.Sprintf("%s/%s", web_path, returned.Name), path.Join(local_path, returned.Name), returned.Type != "file")
+// ReadLocalRepoFiles reads the local copy of a workspace from challenge_folder_path/repo_name, leaving out
+// the paths matched by rules. A missing folder is treated as an empty workspace.
+func ReadLocalRepoFiles(challenge_folder_path string, repo_name string, rules *IgnoreRules) (map[string]RepoFile, error) {
+	files := make(map[string]RepoFile)
+	root := filepath.Join(challenge_folder_path, repo_name)
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return files, nil
+	}
+	err := filepath.Walk(root,
+		func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err
 			}
+			rel_path, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			if rel_path == "." {
+				return nil
+			}
+			rel_path = filepath.ToSlash(rel_path)
+			if rules.Ignored(repo_name, rel_path, info.IsDir()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() {
+				files[rel_path] = RepoFile{IsDir: true, Mode: info.Mode()}
+				return nil
+			}
+			if info.Mode()&os.ModeSymlink != 0 {
+				target, err := os.Readlink(path)
+				if err != nil {
+					return err
+				}
+				file := RepoFile{Mode: info.Mode(), LinkTarget: filepath.ToSlash(target)}
+				if target_info, err := os.Stat(path); err == nil && target_info.Mode().IsRegular() {
+					dat, err := os.ReadFile(path)
+					if err != nil {
+						return err
+					}
+					file.Resolved = &RepoFile{Mode: target_info.Mode(), Contents: dat}
+				}
+				files[rel_path] = file
+				return nil
+			}
+			dat, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			files[rel_path] = RepoFile{Mode: info.Mode(), Contents: dat}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// HashRepoFiles combines the paths, contents, symlinks and executable bits of a set of workspaces into a single hash.
+func HashRepoFiles(repos map[string]map[string]RepoFile) string {
+	entries := make([]string, 0)
+	for repo_name, files := range repos {
+		for rel_path, file := range files {
+			if file.IsDir {
+				entries = append(entries, fmt.Sprintf("%s/%s/", repo_name, rel_path))
+			} else {
+				entries = append(entries, fmt.Sprintf("%s/%s\x00%s", repo_name, rel_path, file.Hash()))
+			}
 		}
 	}
+	sort.Strings(entries)
+	return FileSha([]byte(strings.Join(entries, "\n")))
+}
 
-	return nil
+// RemoteWorkspaceHash hashes the scaffold, solution and testbase workspaces as they currently are in Ed.
+// Paths matched by rules are left out, as they are never uploaded.
+func RemoteWorkspaceHash(conn *client.Client, challenge_id int, rules *IgnoreRules) (string, error) {
+	repos := make(map[string]map[string]RepoFile)
+	for _, repo_name := range ChallengeRepos {
+		files, err := ReadRepoFiles(conn, challenge_id, repo_name)
+		if err != nil {
+			return "", err
+		}
+		repos[repo_name] = rules.Filter(repo_name, files)
+	}
+	return HashRepoFiles(repos), nil
 }
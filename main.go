@@ -5,17 +5,22 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"path"
 	"strconv"
+	"strings"
 
 	"terraform-provider-edstem/internal/client"
 	"terraform-provider-edstem/internal/md2ed"
 	"terraform-provider-edstem/internal/provider"
 	"terraform-provider-edstem/internal/resourceclients"
+	"terraform-provider-edstem/internal/testrunner"
+	"terraform-provider-edstem/internal/wshelpers"
 
 	"github.com/akamensky/argparse"
 	"github.com/hashicorp/terraform-plugin-framework/providerserver"
@@ -58,6 +63,7 @@ func import_tf(object_type string, args ImportArgs) error {
 	if err != nil {
 		return err
 	}
+	client.WorkspaceBackend = os.Getenv("EDSTEM_WORKSPACE_BACKEND")
 
 	var tf string
 	var resources []string
@@ -170,9 +176,239 @@ provider "edstem" {
 	return nil
 }
 
+type ExportArgs struct {
+	CourseId string
+	LessonId *string
+	SlideId  *string
+
+	PerCourse  bool
+	FolderPath string
+}
+
+func export_workspaces(args ExportArgs) error {
+	var token = os.Getenv("EDSTEM_TOKEN")
+	if token == "" {
+		return fmt.Errorf("Please provide the EDSTEM_TOKEN environment variable")
+	}
+	var client, err = client.NewClient(&args.CourseId, &token)
+	if err != nil {
+		return err
+	}
+	client.WorkspaceBackend = os.Getenv("EDSTEM_WORKSPACE_BACKEND")
+
+	var lesson_id *int
+	if args.LessonId != nil {
+		id, err := strconv.Atoi(*args.LessonId)
+		if err != nil {
+			return err
+		}
+		lesson_id = &id
+	}
+	var slide_id *int
+	if args.SlideId != nil {
+		id, err := strconv.Atoi(*args.SlideId)
+		if err != nil {
+			return err
+		}
+		slide_id = &id
+	}
+
+	challenges, err := resourceclients.FindChallenges(client, lesson_id, slide_id)
+	if err != nil {
+		return err
+	}
+	if len(challenges) == 0 {
+		return fmt.Errorf("No challenges found")
+	}
+
+	written, err := resourceclients.ExportWorkspaces(client, challenges, args.FolderPath, args.PerCourse)
+	for _, archive_path := range written {
+		fmt.Println("Wrote", archive_path)
+	}
+	return err
+}
+
+type TestArgs struct {
+	FolderPath     string
+	OverlayPaths   []string
+	TemplateVars   []string
+	TestcasesPath  string
+	BuildCommand   string
+	RunCommand     string
+	IgnorePatterns []string
+	CpuTime        int
+	WallTime       int
+	Memory         int
+	OutputSize     int
+}
+
+// parseTemplateVars reads template variables given as name=value.
+func parseTemplateVars(vars []string) (map[string]string, error) {
+	if len(vars) == 0 {
+		return nil, nil
+	}
+	template_vars := make(map[string]string)
+	for _, v := range vars {
+		name, value, ok := strings.Cut(v, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("Template variable %q should be given as name=value", v)
+		}
+		template_vars[name] = value
+	}
+	return template_vars, nil
+}
+
+func test_challenge(args TestArgs) (bool, error) {
+	template_vars, err := parseTemplateVars(args.TemplateVars)
+	if err != nil {
+		return false, err
+	}
+	config := testrunner.Config{
+		FolderPath:     args.FolderPath,
+		OverlayPaths:   args.OverlayPaths,
+		TemplateVars:   template_vars,
+		TestcasesPath:  args.TestcasesPath,
+		IgnorePatterns: args.IgnorePatterns,
+		BuildCommand:   args.BuildCommand,
+		RunCommand:     args.RunCommand,
+	}
+	if args.CpuTime > 0 {
+		config.RunLimit.CpuTime.Set(int64(args.CpuTime))
+	}
+	if args.WallTime > 0 {
+		config.RunLimit.WallTime.Set(int64(args.WallTime))
+	}
+	if args.Memory > 0 {
+		config.RunLimit.Memory.Set(int64(args.Memory))
+	}
+	if args.OutputSize > 0 {
+		config.RunLimit.OutputSize.Set(int64(args.OutputSize))
+	}
+
+	results, err := testrunner.Run(config)
+	if len(results) > 0 {
+		fmt.Println(testrunner.Report(results))
+	}
+	if err != nil {
+		return false, err
+	}
+	return testrunner.Passed(results), nil
+}
+
+type ConvertArgs struct {
+	CourseId string
+	LessonId *string
+	SlideId  *string
+	DryRun   bool
+}
+
+func convert_criteria(args ConvertArgs) error {
+	var token = os.Getenv("EDSTEM_TOKEN")
+	if token == "" {
+		return fmt.Errorf("Please provide the EDSTEM_TOKEN environment variable")
+	}
+	var client, err = client.NewClient(&args.CourseId, &token)
+	if err != nil {
+		return err
+	}
+
+	var lesson_id *int
+	if args.LessonId != nil {
+		id, err := strconv.Atoi(*args.LessonId)
+		if err != nil {
+			return err
+		}
+		lesson_id = &id
+	}
+	var slide_id *int
+	if args.SlideId != nil {
+		id, err := strconv.Atoi(*args.SlideId)
+		if err != nil {
+			return err
+		}
+		slide_id = &id
+	}
+
+	challenges, err := resourceclients.FindChallenges(client, lesson_id, slide_id)
+	if err != nil {
+		return err
+	}
+
+	converted := 0
+	failed := 0
+	for _, challenge := range challenges {
+		rubric, err := resourceclients.ConvertChallengeCriteria(client, challenge.LessonId, challenge.SlideId, args.DryRun)
+		if errors.Is(err, resourceclients.ErrNoCriteria) {
+			continue
+		}
+		if err != nil {
+			fmt.Printf("Slide %d (%s): %s\n", challenge.SlideId, challenge.SlideTitle, err.Error())
+			failed++
+			continue
+		}
+		converted++
+		if !args.DryRun {
+			fmt.Printf("Slide %d (%s): converted to a rubric worth %d points\n", challenge.SlideId, challenge.SlideTitle, resourceclients.RubricMaxPoints(rubric))
+			continue
+		}
+		rubric_json, err := json.MarshalIndent(rubric, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Slide %d (%s): would convert to a rubric worth %d points:\n%s\n", challenge.SlideId, challenge.SlideTitle, resourceclients.RubricMaxPoints(rubric), string(rubric_json))
+	}
+
+	fmt.Printf("%d challenge(s) with criteria converted", converted)
+	if args.DryRun {
+		fmt.Print(" (dry run, nothing was saved)")
+	}
+	fmt.Println()
+	if failed > 0 {
+		return fmt.Errorf("%d challenge(s) could not be converted", failed)
+	}
+	return nil
+}
+
+type GenerateArgs struct {
+	FolderPath     string
+	OverlayPaths   []string
+	TemplateVars   []string
+	RunCommand     string
+	IgnorePatterns []string
+	OutputPath     string
+}
+
+func generate_testcases(args GenerateArgs) error {
+	local, err := wshelpers.LoadLocalChallenge(args.FolderPath, args.OverlayPaths, args.IgnorePatterns)
+	if err != nil {
+		return err
+	}
+	local.TemplateVars, err = parseTemplateVars(args.TemplateVars)
+	if err != nil {
+		return err
+	}
+	testcases, err := resourceclients.GenerateTestCases(local, args.RunCommand)
+	if err != nil {
+		return err
+	}
+	testcase_json, err := json.MarshalIndent(testcases, "", "  ")
+	if err != nil {
+		return err
+	}
+	if args.OutputPath == "" {
+		fmt.Println(string(testcase_json))
+		return nil
+	}
+	return os.WriteFile(args.OutputPath, append(testcase_json, '\n'), 0666)
+}
+
 func sysargs() {
 	// terraform plan/apply
 	// go run main.go import_tf lesson temp -c 12108 -l 36778
+	// go run main.go export_workspaces archive -c 12108 --per_course
+	// go run main.go convert_criteria -c 12108 -l 36778 --dry_run
+	// go run main.go generate_testcases temp/challenge -r "python3 main.py" -o temp/challenge/testcases.json
+	// go run main.go test_challenge temp/challenge --run_command "python3 main.py"
 	// go run main.go render_ed examples/provider-install-verification/assets/test.md
 	if len(os.Args) == 1 {
 		// No args.
@@ -218,6 +454,140 @@ func sysargs() {
 		if err != nil {
 			fmt.Println("An error occurred: ", err)
 		}
+	} else if os.Args[1] == "export_workspaces" {
+		parser := argparse.NewParser("export_workspaces", "Saves the scaffold, solution and testbase of every challenge as tar.gz archives with a manifest.\nExample: go run main.go export_workspaces archive -c 12108 --per_course")
+		parser.SelectorPositional([]string{"export_workspaces"}, nil)
+		folder_path := parser.StringPositional(nil)
+		course_id := parser.String("c", "course_id", &argparse.Options{Required: true, Help: "Course ID"})
+		lesson_id := parser.String("l", "lesson_id", &argparse.Options{Required: false, Help: "Only export challenges in this Lesson ID"})
+		slide_id := parser.String("s", "slide_id", &argparse.Options{Required: false, Help: "Only export the challenge on this Slide ID"})
+		per_course := parser.Flag("", "per_course", &argparse.Options{Required: false, Help: "Write a single archive for the course instead of one per challenge"})
+
+		err := parser.Parse(os.Args)
+		if err != nil {
+			fmt.Print(parser.Usage(err))
+			return
+		}
+
+		if *lesson_id == "" {
+			lesson_id = nil
+		}
+		if *slide_id == "" {
+			slide_id = nil
+		}
+
+		args := ExportArgs{
+			CourseId:   *course_id,
+			LessonId:   lesson_id,
+			SlideId:    slide_id,
+			PerCourse:  *per_course,
+			FolderPath: *folder_path,
+		}
+		err = export_workspaces(args)
+		if err != nil {
+			fmt.Println("An error occurred: ", err)
+		}
+	} else if os.Args[1] == "test_challenge" {
+		parser := argparse.NewParser("test_challenge", "Runs the testcases of a challenge folder against its solution locally.\nExample: go run main.go test_challenge temp/challenge --run_command \"python3 main.py\"")
+		parser.SelectorPositional([]string{"test_challenge"}, nil)
+		folder_path := parser.StringPositional(nil)
+		testcases_path := parser.String("t", "testcases", &argparse.Options{Required: false, Help: "Testcases JSON, defaults to testcases.json in the folder"})
+		build_command := parser.String("b", "build_command", &argparse.Options{Required: false, Help: "Command run once before the testcases"})
+		run_command := parser.String("r", "run_command", &argparse.Options{Required: false, Help: "Command for testcases without their own run_command"})
+		ignore_patterns := parser.StringList("i", "ignore_pattern", &argparse.Options{Required: false, Help: "Paths to leave out of the workspace, as in ignore_patterns"})
+		overlay_paths := parser.StringList("", "overlay", &argparse.Options{Required: false, Help: "Shared folders layered under the challenge folder, as in overlay_paths"})
+		template_vars := parser.StringList("", "var", &argparse.Options{Required: false, Help: "Template variable as name=value, as in template_vars"})
+		cpu_time := parser.Int("", "cpu_time", &argparse.Options{Required: false, Help: "CPU time limit in milliseconds for testcases without their own"})
+		wall_time := parser.Int("", "wall_time", &argparse.Options{Required: false, Help: "Wall time limit in milliseconds for testcases without their own"})
+		memory := parser.Int("", "memory", &argparse.Options{Required: false, Help: "Memory limit in bytes for testcases without their own"})
+		output_size := parser.Int("", "output_size", &argparse.Options{Required: false, Help: "Output limit in bytes for testcases without their own"})
+
+		err := parser.Parse(os.Args)
+		if err != nil {
+			fmt.Print(parser.Usage(err))
+			return
+		}
+
+		args := TestArgs{
+			FolderPath:     *folder_path,
+			OverlayPaths:   *overlay_paths,
+			TemplateVars:   *template_vars,
+			TestcasesPath:  *testcases_path,
+			BuildCommand:   *build_command,
+			RunCommand:     *run_command,
+			IgnorePatterns: *ignore_patterns,
+			CpuTime:        *cpu_time,
+			WallTime:       *wall_time,
+			Memory:         *memory,
+			OutputSize:     *output_size,
+		}
+		passed, err := test_challenge(args)
+		if err != nil {
+			fmt.Println("An error occurred: ", err)
+			os.Exit(1)
+		}
+		if !passed {
+			os.Exit(1)
+		}
+	} else if os.Args[1] == "convert_criteria" {
+		parser := argparse.NewParser("convert_criteria", "Converts the criteria of challenges to rubrics.\nExample: go run main.go convert_criteria -c 12108 -l 36778 --dry_run")
+		parser.SelectorPositional([]string{"convert_criteria"}, nil)
+		course_id := parser.String("c", "course_id", &argparse.Options{Required: true, Help: "Course ID"})
+		lesson_id := parser.String("l", "lesson_id", &argparse.Options{Required: false, Help: "Only convert challenges in this Lesson ID"})
+		slide_id := parser.String("s", "slide_id", &argparse.Options{Required: false, Help: "Only convert the challenge on this Slide ID"})
+		dry_run := parser.Flag("", "dry_run", &argparse.Options{Required: false, Help: "Print the rubrics without saving them"})
+
+		err := parser.Parse(os.Args)
+		if err != nil {
+			fmt.Print(parser.Usage(err))
+			return
+		}
+
+		if *lesson_id == "" {
+			lesson_id = nil
+		}
+		if *slide_id == "" {
+			slide_id = nil
+		}
+
+		args := ConvertArgs{
+			CourseId: *course_id,
+			LessonId: lesson_id,
+			SlideId:  slide_id,
+			DryRun:   *dry_run,
+		}
+		err = convert_criteria(args)
+		if err != nil {
+			fmt.Println("An error occurred: ", err)
+		}
+	} else if os.Args[1] == "generate_testcases" {
+		parser := argparse.NewParser("generate_testcases", "Prints the testcases generated from the N.in, N.out and N.args files in a challenge testbase.\nExample: go run main.go generate_testcases temp/challenge -r \"python3 main.py\" -o temp/challenge/testcases.json")
+		parser.SelectorPositional([]string{"generate_testcases"}, nil)
+		folder_path := parser.StringPositional(nil)
+		run_command := parser.String("r", "run_command", &argparse.Options{Required: false, Help: "Command the contents of N.args are appended to"})
+		ignore_patterns := parser.StringList("i", "ignore_pattern", &argparse.Options{Required: false, Help: "Paths to leave out of the testbase, as in ignore_patterns"})
+		overlay_paths := parser.StringList("", "overlay", &argparse.Options{Required: false, Help: "Shared folders layered under the challenge folder, as in overlay_paths"})
+		template_vars := parser.StringList("", "var", &argparse.Options{Required: false, Help: "Template variable as name=value, as in template_vars"})
+		output_path := parser.String("o", "output", &argparse.Options{Required: false, Help: "File to write the testcases to instead of stdout"})
+
+		err := parser.Parse(os.Args)
+		if err != nil {
+			fmt.Print(parser.Usage(err))
+			return
+		}
+
+		args := GenerateArgs{
+			FolderPath:     *folder_path,
+			OverlayPaths:   *overlay_paths,
+			TemplateVars:   *template_vars,
+			RunCommand:     *run_command,
+			IgnorePatterns: *ignore_patterns,
+			OutputPath:     *output_path,
+		}
+		err = generate_testcases(args)
+		if err != nil {
+			fmt.Println("An error occurred: ", err)
+		}
 	} else if os.Args[1] == "render_ed" {
 		fpath := os.Args[2]
 		content, _ := os.ReadFile(fpath)